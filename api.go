@@ -0,0 +1,835 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// projectDocument is the JSON representation of a whole project.
+type projectDocument struct {
+	project
+	Pairs     []dep                 `json:"pairs"`
+	Bubbles   []bubble              `json:"bubbles"`
+	Durations map[string]float64    `json:"durations"`
+	Info      map[string]bubbleInfo `json:"info"`
+}
+
+func newProjectDocument(state projectState) projectDocument {
+	durations := make(map[string]float64)
+	info := make(map[string]bubbleInfo)
+	for _, bubble := range knownBubbles(state.Pairs, state.States) {
+		if d := state.Durations[bubble.Bubble]; d > 0 {
+			durations[bubble.Bubble] = d
+		}
+		if i, ok := state.Info[bubble.Bubble]; ok {
+			info[bubble.Bubble] = i
+		}
+	}
+	return projectDocument{
+		project:   state.Project,
+		Pairs:     state.Pairs,
+		Bubbles:   knownBubbles(state.Pairs, state.States),
+		Durations: durations,
+		Info:      info,
+	}
+}
+
+// bubbleDocument is the JSON representation of a single bubble and its
+// immediate neighbors.
+type bubbleDocument struct {
+	bubble
+	bubbleInfo
+	Duration   float64  `json:"duration"`
+	Upstream   []string `json:"upstream"`
+	Downstream []string `json:"downstream"`
+}
+
+// registerAPIHandlers exposes projects, pairs, bubbles and members as JSON
+// under /api/v1, to signed in users and API tokens. Errors are reported as
+// {"error": "..."}. They are registered on mux.
+func registerAPIHandlers(mux *http.ServeMux, db *sql.DB, dbMu *sync.Mutex) {
+	mux.HandleFunc("GET /api/v1/projects", func(w http.ResponseWriter, r *http.Request) {
+		acct, ok := apiAccount(w, r)
+		if !ok {
+			return
+		}
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		projects, err := listProjects(db, acct.ID)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, projects)
+	})
+
+	mux.HandleFunc("POST /api/v1/projects", func(w http.ResponseWriter, r *http.Request) {
+		acct, ok := apiAccount(w, r)
+		if !ok {
+			return
+		}
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := readJSON(r, &req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" {
+			writeJSONError(w, http.StatusBadRequest, errors.New("name is required"))
+			return
+		}
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		var pID int64
+		err := withJournal(db, actorOf(r), func(j *journal) error {
+			var err error
+			if pID, err = createProject(j, req.Name); err != nil {
+				return err
+			}
+			return setMember(j, pID, acct.ID, owner)
+		})
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.Header().Set("Location", fmt.Sprintf("/api/v1/projects/%v", pID))
+		writeJSON(w, http.StatusCreated, project{ID: uint64(pID), Name: req.Name})
+	})
+
+	mux.HandleFunc("GET /api/v1/projects/{id}", func(w http.ResponseWriter, r *http.Request) {
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		pID, ok := apiProjectID(w, r, db, viewer)
+		if !ok {
+			return
+		}
+		state, err := captureState(db, pID)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, newProjectDocument(state))
+	})
+
+	mux.HandleFunc("PATCH /api/v1/projects/{id}", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Name            *string `json:"name"`
+			AbortedUnblocks *bool   `json:"aborted_unblocks"`
+			Strict          *bool   `json:"strict"`
+			Start           *string `json:"start"`
+		}
+		if err := readJSON(r, &req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		if req.Start != nil {
+			start, err := parseStartDate(*req.Start)
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, err)
+				return
+			}
+			req.Start = &start
+		}
+		if req.Name != nil && strings.TrimSpace(*req.Name) == "" {
+			writeJSONError(w, http.StatusBadRequest, errors.New("name cannot be empty"))
+			return
+		}
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		pID, ok := apiProjectID(w, r, db, editor)
+		if !ok {
+			return
+		}
+		p, err := loadProject(db, pID)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if req.Name != nil {
+			p.Name = strings.TrimSpace(*req.Name)
+		}
+		if req.AbortedUnblocks != nil {
+			p.AbortedUnblocks = *req.AbortedUnblocks
+		}
+		if req.Strict != nil {
+			p.Strict = *req.Strict
+		}
+		if req.Start != nil {
+			p.Start = *req.Start
+		}
+		err = withJournal(db, actorOf(r), func(j *journal) error {
+			return saveProject(j, p)
+		})
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, p)
+	})
+
+	mux.HandleFunc("DELETE /api/v1/projects/{id}", func(w http.ResponseWriter, r *http.Request) {
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		pID, ok := apiProjectID(w, r, db, owner)
+		if !ok {
+			return
+		}
+		err := withJournal(db, actorOf(r), func(j *journal) error {
+			return deleteProject(j, pID)
+		})
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	for _, stack := range []string{undoStack, redoStack} {
+		move := undo
+		if stack == redoStack {
+			move = redo
+		}
+		mux.HandleFunc("POST /api/v1/projects/{id}/"+stack, func(w http.ResponseWriter, r *http.Request) {
+			dbMu.Lock()
+			defer dbMu.Unlock()
+			pID, ok := apiProjectID(w, r, db, editor)
+			if !ok {
+				return
+			}
+			var label string
+			err := withJournal(db, actorOf(r), func(j *journal) error {
+				var err error
+				label, err = move(j, pID)
+				return err
+			})
+			if err != nil {
+				writeJSONError(w, apiErrorStatus(err), err)
+				return
+			}
+			writeJSON(w, http.StatusOK, struct {
+				Reverted string `json:"reverted"`
+			}{label})
+		})
+	}
+
+	mux.HandleFunc("GET /api/v1/projects/{id}/snapshots", func(w http.ResponseWriter, r *http.Request) {
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		pID, ok := apiProjectID(w, r, db, viewer)
+		if !ok {
+			return
+		}
+		snapshots, err := listSnapshots(db, pID)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, snapshots)
+	})
+
+	mux.HandleFunc("POST /api/v1/projects/{id}/snapshots", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := readJSON(r, &req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" {
+			writeJSONError(w, http.StatusBadRequest, errors.New("name is required"))
+			return
+		}
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		pID, ok := apiProjectID(w, r, db, editor)
+		if !ok {
+			return
+		}
+		s, err := createSnapshot(db, pID, req.Name)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.Header().Set("Location", fmt.Sprintf("/api/v1/projects/%v/snapshots/%v", pID, s.ID))
+		writeJSON(w, http.StatusCreated, s)
+	})
+
+	mux.HandleFunc("GET /api/v1/projects/{id}/snapshots/{sid}", func(w http.ResponseWriter, r *http.Request) {
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		pID, ok := apiProjectID(w, r, db, viewer)
+		if !ok {
+			return
+		}
+		s, ok := apiSnapshot(w, r, db, pID)
+		if !ok {
+			return
+		}
+		writeJSON(w, http.StatusOK, s)
+	})
+
+	mux.HandleFunc("GET /api/v1/projects/{id}/snapshots/{sid}/diff", func(w http.ResponseWriter, r *http.Request) {
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		pID, ok := apiProjectID(w, r, db, viewer)
+		if !ok {
+			return
+		}
+		s, ok := apiSnapshot(w, r, db, pID)
+		if !ok {
+			return
+		}
+		current, err := captureState(db, pID)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, diffStates(*s.State, current))
+	})
+
+	mux.HandleFunc("DELETE /api/v1/projects/{id}/snapshots/{sid}", func(w http.ResponseWriter, r *http.Request) {
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		pID, ok := apiProjectID(w, r, db, editor)
+		if !ok {
+			return
+		}
+		s, ok := apiSnapshot(w, r, db, pID)
+		if !ok {
+			return
+		}
+		if _, err := deleteSnapshot(db, pID, s.ID); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("GET /api/v1/projects/{id}/pairs", func(w http.ResponseWriter, r *http.Request) {
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		pID, ok := apiProjectID(w, r, db, viewer)
+		if !ok {
+			return
+		}
+		deps, err := loadPairs(db, pID)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, deps)
+	})
+
+	mux.HandleFunc("POST /api/v1/projects/{id}/pairs", func(w http.ResponseWriter, r *http.Request) {
+		var req dep
+		if err := readJSON(r, &req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		req.Left, req.Right = strings.TrimSpace(req.Left), strings.TrimSpace(req.Right)
+		if req.Left == "" || req.Right == "" {
+			writeJSONError(w, http.StatusBadRequest, errors.New("left and right are required"))
+			return
+		}
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		pID, ok := apiProjectID(w, r, db, editor)
+		if !ok {
+			return
+		}
+		var inserted bool
+		err := withJournal(db, actorOf(r), func(j *journal) error {
+			var err error
+			inserted, err = insertPair(j, pID, req.Left, req.Right)
+			return err
+		})
+		if err != nil {
+			writeJSONError(w, apiErrorStatus(err), err)
+			return
+		}
+		status := http.StatusOK
+		if inserted {
+			status = http.StatusCreated
+		}
+		writeJSON(w, status, req)
+	})
+
+	mux.HandleFunc("DELETE /api/v1/projects/{id}/pairs/{left}/{right}", func(w http.ResponseWriter, r *http.Request) {
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		pID, ok := apiProjectID(w, r, db, editor)
+		if !ok {
+			return
+		}
+		var removed bool
+		err := withJournal(db, actorOf(r), func(j *journal) error {
+			var err error
+			removed, err = removePair(j, pID, r.PathValue("left"), r.PathValue("right"))
+			return err
+		})
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		if !removed {
+			writeJSONError(w, http.StatusNotFound, errors.New("pair not found"))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("GET /api/v1/projects/{id}/bubbles", func(w http.ResponseWriter, r *http.Request) {
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		pID, ok := apiProjectID(w, r, db, viewer)
+		if !ok {
+			return
+		}
+		deps, states, err := loadGraph(db, pID)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, knownBubbles(deps, states))
+	})
+
+	mux.HandleFunc("GET /api/v1/projects/{id}/ready", func(w http.ResponseWriter, r *http.Request) {
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		pID, ok := apiProjectID(w, r, db, viewer)
+		if !ok {
+			return
+		}
+		p, err := loadProject(db, pID)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		deps, states, err := loadGraph(db, pID)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, readyBubbles(deps, states, p.AbortedUnblocks))
+	})
+
+	mux.HandleFunc("GET /api/v1/projects/{id}/events", func(w http.ResponseWriter, r *http.Request) {
+		limit := historySize
+		if v := r.URL.Query().Get("limit"); v != "" {
+			var err error
+			if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+				writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid limit %q", v))
+				return
+			}
+		}
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		pID, ok := apiProjectID(w, r, db, viewer)
+		if !ok {
+			return
+		}
+		events, err := loadEvents(db, pID, limit)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, events)
+	})
+
+	mux.HandleFunc("GET /api/v1/projects/{id}/bubbles/{name}", func(w http.ResponseWriter, r *http.Request) {
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		pID, ok := apiProjectID(w, r, db, viewer)
+		if !ok {
+			return
+		}
+		doc, err := loadBubbleDocument(db, pID, r.PathValue("name"))
+		if err != nil {
+			writeJSONError(w, apiErrorStatus(err), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, doc)
+	})
+
+	mux.HandleFunc("PATCH /api/v1/projects/{id}/bubbles/{name}", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Name        *string   `json:"name"`
+			State       *string   `json:"state"`
+			Duration    *float64  `json:"duration"`
+			Description *string   `json:"description"`
+			Assignee    *string   `json:"assignee"`
+			Due         *string   `json:"due"`
+			URL         *string   `json:"url"`
+			Tags        *[]string `json:"tags"`
+		}
+		if err := readJSON(r, &req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		if req.Name != nil && strings.TrimSpace(*req.Name) == "" {
+			writeJSONError(w, http.StatusBadRequest, errors.New("name cannot be empty"))
+			return
+		}
+		var state bubbleState
+		if req.State != nil {
+			var err error
+			if state, err = parseBubbleState(*req.State); err != nil {
+				writeJSONError(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		pID, ok := apiProjectID(w, r, db, editor)
+		if !ok {
+			return
+		}
+		name := r.PathValue("name")
+		current, err := loadBubbleDocument(db, pID, name)
+		if err != nil {
+			writeJSONError(w, apiErrorStatus(err), err)
+			return
+		}
+		info := current.bubbleInfo
+		if req.Description != nil {
+			info.Description = *req.Description
+		}
+		if req.Assignee != nil {
+			info.Assignee = *req.Assignee
+		}
+		if req.Due != nil {
+			info.Due = *req.Due
+		}
+		if req.URL != nil {
+			info.URL = *req.URL
+		}
+		if req.Tags != nil {
+			info.Tags = *req.Tags
+		}
+		if info, err = parseBubbleInfo(info.Description, info.Assignee, info.Due, info.URL, info.Tags); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		err = withJournal(db, actorOf(r), func(j *journal) error {
+			if err := changeBubbleInfo(j, pID, name, info); err != nil {
+				return err
+			}
+			if req.State != nil {
+				if err := changeBubbleState(j, pID, name, state); err != nil {
+					return err
+				}
+			}
+			if req.Duration != nil {
+				if err := changeBubbleDuration(j, pID, name, *req.Duration); err != nil {
+					return err
+				}
+			}
+			if req.Name != nil {
+				to := strings.TrimSpace(*req.Name)
+				if err := renameBubble(j, pID, name, to); err != nil {
+					return err
+				}
+				name = to
+			}
+			return nil
+		})
+		if err != nil {
+			writeJSONError(w, apiErrorStatus(err), err)
+			return
+		}
+		doc, err := loadBubbleDocument(db, pID, name)
+		if err != nil {
+			writeJSONError(w, apiErrorStatus(err), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, doc)
+	})
+
+	mux.HandleFunc("POST /api/v1/projects/{id}/bubbles/{name}/flip", func(w http.ResponseWriter, r *http.Request) {
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		pID, ok := apiProjectID(w, r, db, editor)
+		if !ok {
+			return
+		}
+		name := r.PathValue("name")
+		if _, err := loadBubbleDocument(db, pID, name); err != nil {
+			writeJSONError(w, apiErrorStatus(err), err)
+			return
+		}
+		err := withJournal(db, actorOf(r), func(j *journal) error {
+			_, err := flipBubble(j, pID, name)
+			return err
+		})
+		if err != nil {
+			writeJSONError(w, apiErrorStatus(err), err)
+			return
+		}
+		doc, err := loadBubbleDocument(db, pID, name)
+		if err != nil {
+			writeJSONError(w, apiErrorStatus(err), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, doc)
+	})
+
+	mux.HandleFunc("DELETE /api/v1/projects/{id}/bubbles/{name}", func(w http.ResponseWriter, r *http.Request) {
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		pID, ok := apiProjectID(w, r, db, editor)
+		if !ok {
+			return
+		}
+		name := r.PathValue("name")
+		if _, err := loadBubbleDocument(db, pID, name); err != nil {
+			writeJSONError(w, apiErrorStatus(err), err)
+			return
+		}
+		err := withJournal(db, actorOf(r), func(j *journal) error {
+			return deleteBubble(j, pID, name)
+		})
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("GET /api/v1/projects/{id}/members", func(w http.ResponseWriter, r *http.Request) {
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		pID, ok := apiProjectID(w, r, db, viewer)
+		if !ok {
+			return
+		}
+		members, err := listMembers(db, pID)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, members)
+	})
+
+	mux.HandleFunc("PUT /api/v1/projects/{id}/members/{name}", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Role string `json:"role"`
+		}
+		if err := readJSON(r, &req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		newRole, err := parseRole(req.Role)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err)
+			return
+		}
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		pID, ok := apiProjectID(w, r, db, owner)
+		if !ok {
+			return
+		}
+		acct, err := findUser(db, r.PathValue("name"))
+		if err != nil {
+			writeJSONError(w, apiErrorStatus(err), err)
+			return
+		}
+		if err := setMember(db, pID, acct.ID, newRole); err != nil {
+			writeJSONError(w, apiErrorStatus(err), err)
+			return
+		}
+		writeJSON(w, http.StatusOK, member{account: acct, Role: newRole})
+	})
+
+	mux.HandleFunc("DELETE /api/v1/projects/{id}/members/{name}", func(w http.ResponseWriter, r *http.Request) {
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		pID, ok := apiProjectID(w, r, db, owner)
+		if !ok {
+			return
+		}
+		acct, err := findUser(db, r.PathValue("name"))
+		if err != nil {
+			writeJSONError(w, apiErrorStatus(err), err)
+			return
+		}
+		if err := removeMember(db, pID, acct.ID); err != nil {
+			writeJSONError(w, apiErrorStatus(err), err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// apiAccount returns the user making the request. When it returns false,
+// the error response has already been written.
+func apiAccount(w http.ResponseWriter, r *http.Request) (account, bool) {
+	acct, ok := accountOf(r)
+	if !ok {
+		if _, bearer := bearerToken(r); bearer {
+			writeJSONError(w, http.StatusUnauthorized, errTokenNotFound)
+		} else {
+			writeJSONError(w, http.StatusUnauthorized, errSignedOut)
+		}
+		return account{}, false
+	}
+	if _, limited := tokenProject(r); limited {
+		writeJSONError(w, http.StatusForbidden, errTokenScope)
+		return account{}, false
+	}
+	return acct, true
+}
+
+// apiProjectID parses the {id} path value and checks that the project
+// exists and that the user making the request has at least the role need in
+// it. When it returns false, the error response has already been written.
+func apiProjectID(w http.ResponseWriter, r *http.Request, q queryer, need role) (int64, bool) {
+	pID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid project id: %w", err))
+		return 0, false
+	}
+	// Projects the caller cannot see are not found, so that their IDs do
+	// not tell which projects exist.
+	have, err := checkRole(q, r, pID, viewer)
+	if errors.Is(err, errForbidden) || errors.Is(err, errTokenScope) {
+		writeJSONError(w, http.StatusNotFound, errors.New("project not found"))
+		return 0, false
+	} else if err != nil {
+		writeJSONError(w, apiErrorStatus(err), err)
+		return 0, false
+	}
+	if !have.allows(need) {
+		writeJSONError(w, http.StatusForbidden, errForbidden)
+		return 0, false
+	}
+	if _, err := loadProject(q, pID); errors.Is(err, errNotFound) {
+		writeJSONError(w, http.StatusNotFound, errors.New("project not found"))
+		return 0, false
+	} else if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return 0, false
+	}
+	return pID, true
+}
+
+// apiSnapshot loads the snapshot named by the {sid} path value. When it
+// returns false, the error response has already been written.
+func apiSnapshot(w http.ResponseWriter, r *http.Request, q queryer, pID int64) (snapshot, bool) {
+	sID, err := strconv.ParseInt(r.PathValue("sid"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, fmt.Errorf("invalid snapshot id: %w", err))
+		return snapshot{}, false
+	}
+	s, err := loadSnapshot(q, pID, sID)
+	if errors.Is(err, errNotFound) {
+		writeJSONError(w, http.StatusNotFound, errors.New("snapshot not found"))
+		return snapshot{}, false
+	} else if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return snapshot{}, false
+	}
+	return s, true
+}
+
+// loadBubbleDocument returns errNotFound if the bubble is not part of any
+// pair of the project.
+func loadBubbleDocument(q queryer, pID int64, name string) (bubbleDocument, error) {
+	deps, states, err := loadGraph(q, pID)
+	if err != nil {
+		return bubbleDocument{}, err
+	}
+	doc := bubbleDocument{
+		bubble:     bubble{Bubble: name, State: states[name]},
+		Upstream:   []string{},
+		Downstream: []string{},
+	}
+	found := false
+	for _, dep := range deps {
+		if dep.Right == name {
+			doc.Upstream = append(doc.Upstream, dep.Left)
+			found = true
+		}
+		if dep.Left == name {
+			doc.Downstream = append(doc.Downstream, dep.Right)
+			found = true
+		}
+	}
+	if !found {
+		return bubbleDocument{}, fmt.Errorf("bubble %q: %w", name, errNotFound)
+	}
+	if doc.State == "" {
+		doc.State = initial
+	}
+	if doc.bubbleInfo, err = bubbleInfoOf(q, pID, name); err != nil {
+		return bubbleDocument{}, err
+	}
+	doc.Duration, err = bubbleDurationOf(q, pID, name)
+	return doc, err
+}
+
+// apiErrorStatus maps storage errors to HTTP status codes.
+func apiErrorStatus(err error) int {
+	var sqliteErr sqlite3.Error
+	var cycleErr *cycleError
+	var blockedErr *blockedError
+	switch {
+	case errors.Is(err, errNotFound):
+		return http.StatusNotFound
+	case errors.As(err, &sqliteErr) && sqliteErr.Code == sqlite3.ErrConstraint:
+		return http.StatusConflict
+	case errors.As(err, &cycleErr), errors.As(err, &blockedErr):
+		return http.StatusConflict
+	case errors.Is(err, errNothingToUndo), errors.Is(err, errNothingToRedo):
+		return http.StatusConflict
+	case errors.Is(err, errInvalidDuration), errors.Is(err, errInvalidRole):
+		return http.StatusBadRequest
+	case errors.Is(err, errSignedOut):
+		return http.StatusUnauthorized
+	case errors.Is(err, errForbidden), errors.Is(err, errTokenScope):
+		return http.StatusForbidden
+	case errors.Is(err, errLastOwner):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func readJSON(r *http.Request, v any) error {
+	dec := json.NewDecoder(http.MaxBytesReader(nil, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("invalid request body: %w", err)
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		log.Printf("cannot encode response: %v", err)
+	}
+}
+
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, apiError{Error: err.Error()})
+}
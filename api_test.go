@@ -0,0 +1,262 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// apiClient sends requests to the API handlers of a test database.
+type apiClient struct {
+	handler http.Handler
+}
+
+func newAPIClient(db *sql.DB) apiClient {
+	var dbMu sync.Mutex
+	mux := http.NewServeMux()
+	registerAPIHandlers(mux, db, &dbMu)
+	return apiClient{handler: withSession(db, &dbMu, mux)}
+}
+
+// do sends body, if any, as the bearer of token, if any.
+func (c apiClient) do(method, path, token, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	c.handler.ServeHTTP(w, r)
+	return w
+}
+
+// newTestMember signs up a user with the role in the project and returns an
+// API token of theirs for all their projects.
+func newTestMember(t *testing.T, db *sql.DB, pID int64, name string, r role) string {
+	t.Helper()
+	acct, err := createUser(db, name, "secret123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r != "" {
+		if err := setMember(db, pID, acct.ID, r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	token, err := createAPIToken(db, acct.ID, 0, name, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestAPIProjectAccess(t *testing.T) {
+	db := newTestDB(t)
+	pID := newTestProject(t, db, dep{Left: "a", Right: "b"})
+	other := newTestProject(t, db)
+	ownerToken := newTestMember(t, db, pID, "ann", owner)
+	viewerToken := newTestMember(t, db, pID, "vic", viewer)
+	outsiderToken := newTestMember(t, db, other, "out", owner)
+	acct, err := findUser(db, "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := setMember(db, pID, acct.ID, viewer); err != nil {
+		t.Fatal(err)
+	}
+	scopedToken, err := createAPIToken(db, acct.ID, other, "other only", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newAPIClient(db)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		status int
+	}{
+		{"signed out", "GET", fmt.Sprintf("/api/v1/projects/%v", pID), "", http.StatusUnauthorized},
+		{"unknown token", "GET", fmt.Sprintf("/api/v1/projects/%v", pID), "nope", http.StatusUnauthorized},
+		{"owner", "GET", fmt.Sprintf("/api/v1/projects/%v", pID), ownerToken, http.StatusOK},
+		{"viewer", "GET", fmt.Sprintf("/api/v1/projects/%v", pID), viewerToken, http.StatusOK},
+		{"viewer cannot edit", "POST", fmt.Sprintf("/api/v1/projects/%v/bubbles/a/flip", pID), viewerToken, http.StatusForbidden},
+		{"not a member", "GET", fmt.Sprintf("/api/v1/projects/%v", other), ownerToken, http.StatusNotFound},
+		{"no such project", "GET", "/api/v1/projects/999", ownerToken, http.StatusNotFound},
+		{"token of another project", "GET", fmt.Sprintf("/api/v1/projects/%v", pID), scopedToken, http.StatusNotFound},
+		{"token of the project", "GET", fmt.Sprintf("/api/v1/projects/%v", other), scopedToken, http.StatusOK},
+		{"scoped token cannot list", "GET", "/api/v1/projects", scopedToken, http.StatusForbidden},
+		{"invalid id", "GET", "/api/v1/projects/x", outsiderToken, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := c.do(tt.method, tt.path, tt.token, "")
+			if w.Code != tt.status {
+				t.Errorf("%v %v = %v, want %v: %s", tt.method, tt.path, w.Code, tt.status, w.Body)
+			}
+		})
+	}
+
+	// A missing project and one the caller cannot see look the same.
+	hidden := c.do("GET", fmt.Sprintf("/api/v1/projects/%v", other), ownerToken, "")
+	missing := c.do("GET", "/api/v1/projects/999", ownerToken, "")
+	if hidden.Body.String() != missing.Body.String() {
+		t.Errorf("hidden project = %s, missing project = %s", hidden.Body, missing.Body)
+	}
+}
+
+func TestAPIPatchBubble(t *testing.T) {
+	tests := []struct {
+		name   string
+		bubble string
+		body   string
+		status int
+		want   bubbleDocument
+	}{
+		{
+			name:   "info",
+			bubble: "b",
+			body:   `{"description": "build it", "assignee": "ann", "tags": ["web", "api"]}`,
+			status: http.StatusOK,
+			want:   bubbleDocument{bubble: bubble{Bubble: "b", State: initial}, bubbleInfo: bubbleInfo{Description: "build it", Assignee: "ann", Tags: []string{"api", "web"}}},
+		},
+		{
+			name:   "state and duration",
+			bubble: "b",
+			body:   `{"state": "started", "duration": 2.5}`,
+			status: http.StatusOK,
+			want:   bubbleDocument{bubble: bubble{Bubble: "b", State: started}, Duration: 2.5},
+		},
+		{
+			name:   "everything and a rename",
+			bubble: "b",
+			body:   `{"name": " build ", "state": "done", "duration": 3, "due": "2030-01-02"}`,
+			status: http.StatusOK,
+			want:   bubbleDocument{bubble: bubble{Bubble: "build", State: done}, bubbleInfo: bubbleInfo{Due: "2030-01-02"}, Duration: 3},
+		},
+		{
+			name:   "unknown bubble",
+			bubble: "zzz",
+			body:   `{"state": "done"}`,
+			status: http.StatusNotFound,
+		},
+		{
+			name:   "unknown field",
+			bubble: "b",
+			body:   `{"state": "done", "colour": "red"}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "invalid state",
+			bubble: "b",
+			body:   `{"state": "finished"}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "empty name",
+			bubble: "b",
+			body:   `{"name": "  "}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "invalid due day",
+			bubble: "b",
+			body:   `{"due": "tomorrow"}`,
+			status: http.StatusBadRequest,
+		},
+		{
+			// The state is not changed when the duration is refused.
+			name:   "invalid duration",
+			bubble: "b",
+			body:   `{"state": "done", "duration": -1}`,
+			status: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			pID := newTestProject(t, db, dep{Left: "a", Right: "b"}, dep{Left: "b", Right: "c"})
+			token := newTestMember(t, db, pID, "ann", editor)
+			c := newAPIClient(db)
+			w := c.do("PATCH", fmt.Sprintf("/api/v1/projects/%v/bubbles/%v", pID, tt.bubble), token, tt.body)
+			if w.Code != tt.status {
+				t.Fatalf("status = %v, want %v: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status != http.StatusOK {
+				var apiErr apiError
+				if err := json.Unmarshal(w.Body.Bytes(), &apiErr); err != nil || apiErr.Error == "" {
+					t.Errorf("error body = %s", w.Body)
+				}
+				doc, err := loadBubbleDocument(db, pID, "b")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if doc.State != initial || doc.Duration != 0 || doc.bubbleInfo.Due != "" {
+					t.Errorf("a refused patch changed the bubble: %+v", doc)
+				}
+				return
+			}
+			var got bubbleDocument
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Bubble != tt.want.Bubble || got.State != tt.want.State || got.Duration != tt.want.Duration ||
+				got.Description != tt.want.Description || got.Assignee != tt.want.Assignee || got.Due != tt.want.Due ||
+				!slices.Equal(got.Tags, tt.want.Tags) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+			if !slices.Equal(got.Upstream, []string{"a"}) || !slices.Equal(got.Downstream, []string{"c"}) {
+				t.Errorf("neighbors = %v and %v, want a and c", got.Upstream, got.Downstream)
+			}
+			stored, err := loadBubbleDocument(db, pID, tt.want.Bubble)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stored.State != tt.want.State || stored.Duration != tt.want.Duration {
+				t.Errorf("stored %+v, want %+v", stored, tt.want)
+			}
+		})
+	}
+}
+
+func TestAPIErrorStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{errNotFound, http.StatusNotFound},
+		{fmt.Errorf("bubble %q: %w", "a", errNotFound), http.StatusNotFound},
+		{sqlite3.Error{Code: sqlite3.ErrConstraint}, http.StatusConflict},
+		{sqlite3.Error{Code: sqlite3.ErrBusy}, http.StatusInternalServerError},
+		{&cycleError{Path: []string{"a", "a"}}, http.StatusConflict},
+		{fmt.Errorf("line 2: %w", &cycleError{Path: []string{"a", "a"}}), http.StatusConflict},
+		{&blockedError{}, http.StatusConflict},
+		{errNothingToUndo, http.StatusConflict},
+		{errNothingToRedo, http.StatusConflict},
+		{errInvalidDuration, http.StatusBadRequest},
+		{errInvalidRole, http.StatusBadRequest},
+		{errSignedOut, http.StatusUnauthorized},
+		{errForbidden, http.StatusForbidden},
+		{errTokenScope, http.StatusForbidden},
+		{errLastOwner, http.StatusConflict},
+		{errors.New("disk full"), http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		if got := apiErrorStatus(tt.err); got != tt.want {
+			t.Errorf("apiErrorStatus(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// role is what a member may do in a project. Each role can do everything
+// the ones before it in roles can.
+type role string
+
+const (
+	// viewer can look at the project.
+	viewer role = "viewer"
+	// editor can change the graph, the bubbles and the settings.
+	editor role = "editor"
+	// owner can also manage the members and delete the project.
+	owner role = "owner"
+)
+
+var roles = []role{viewer, editor, owner}
+
+var (
+	errInvalidRole  = errors.New("role must be viewer, editor or owner")
+	errSignedOut    = errors.New("sign in first")
+	errForbidden    = errors.New("not allowed in this project")
+	errBadLogin     = errors.New("unknown user or wrong password")
+	errNameTaken    = errors.New("user name already taken")
+	errInvalidLogin = errors.New("user name cannot be empty and passwords need at least 8 characters")
+	errLastOwner    = errors.New("a project needs at least one owner")
+	errBadClaim     = errors.New("wrong or used claim token")
+)
+
+func parseRole(s string) (role, error) {
+	if r := role(s); slices.Contains(roles, r) {
+		return r, nil
+	}
+	return "", errInvalidRole
+}
+
+// allows tells whether the role covers what need can do.
+func (r role) allows(need role) bool {
+	return slices.Index(roles, r) >= slices.Index(roles, need)
+}
+
+// CanEdit and CanManage are for the templates.
+func (r role) CanEdit() bool   { return r.allows(editor) }
+func (r role) CanManage() bool { return r.allows(owner) }
+
+// account is a user who can sign in.
+type account struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+const (
+	sessionCookie = "bubbles_session"
+	sessionTTL    = 30 * 24 * time.Hour
+	minPassword   = 8
+)
+
+// createUser adds an account.
+func createUser(q queryer, name, password string) (account, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(password) < minPassword {
+		return account{}, errInvalidLogin
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return account{}, err
+	}
+	result, err := q.Exec("insert into users (name, password, created) values (?, ?, ?)", name, hash, time.Now().UTC())
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.Code == sqlite3.ErrConstraint {
+		return account{}, errNameTaken
+	} else if err != nil {
+		return account{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return account{}, err
+	}
+	return account{ID: id, Name: name}, nil
+}
+
+// unclaimedProjects counts the projects without members, as made before
+// there were accounts.
+func unclaimedProjects(q queryer) (int, error) {
+	var n int
+	err := q.QueryRow("select count(*) from projects where project not in (select project from members)").Scan(&n)
+	return n, err
+}
+
+// claimProjects makes the user the owner of the projects without members.
+// Only someone holding the claim token printed at startup may do so.
+func claimProjects(q queryer, userID int64) (int64, error) {
+	result, err := q.Exec(`
+		insert into members (project, user, role)
+			select project, ?, ? from projects where project not in (select project from members)
+	`, userID, owner)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// checkPassword returns the account whose name and password match.
+func checkPassword(q queryer, name, password string) (account, error) {
+	var (
+		acct account
+		hash []byte
+	)
+	err := q.QueryRow("select id, name, password from users where name = ?", strings.TrimSpace(name)).Scan(&acct.ID, &acct.Name, &hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return account{}, errBadLogin
+	} else if err != nil {
+		return account{}, err
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return account{}, errBadLogin
+	}
+	return acct, nil
+}
+
+func findUser(q queryer, name string) (account, error) {
+	var acct account
+	err := q.QueryRow("select id, name from users where name = ?", strings.TrimSpace(name)).Scan(&acct.ID, &acct.Name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return account{}, fmt.Errorf("user %q: %w", name, errNotFound)
+	}
+	return acct, err
+}
+
+// newToken returns a random, unguessable token.
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken is how tokens are kept in the database, so that reading it does
+// not give away working tokens.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// startSession signs the user in, returning the cookie that carries the
+// session.
+func startSession(q queryer, r *http.Request, userID int64) (*http.Cookie, error) {
+	token, err := newToken()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	if _, err := q.Exec("delete from sessions where expires < ?", now); err != nil {
+		return nil, err
+	}
+	expires := now.Add(sessionTTL)
+	if _, err := q.Exec("insert into sessions (token, user, expires) values (?, ?, ?)", hashToken(token), userID, expires); err != nil {
+		return nil, err
+	}
+	return &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	}, nil
+}
+
+// endSession signs the user out, returning the cookie that clears the
+// session.
+func endSession(q queryer, r *http.Request) (*http.Cookie, error) {
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		if _, err := q.Exec("delete from sessions where token = ?", hashToken(c.Value)); err != nil {
+			return nil, err
+		}
+	}
+	return &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1, HttpOnly: true}, nil
+}
+
+func sessionUser(q queryer, token string) (account, error) {
+	var acct account
+	err := q.QueryRow(`
+		select users.id, users.name from sessions join users on users.id = sessions.user
+			where sessions.token = ? and sessions.expires > ?
+	`, hashToken(token), time.Now().UTC()).Scan(&acct.ID, &acct.Name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return account{}, errSignedOut
+	}
+	return acct, err
+}
+
+type accountKey struct{}
+
+// withSession finds who is signed in, by their session cookie or their API
+// token, and keeps it in the request context, for accountOf.
+func withSession(db *sql.DB, dbMu *sync.Mutex, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token, ok := bearerToken(r); ok {
+			dbMu.Lock()
+			t, acct, err := useAPIToken(db, token)
+			dbMu.Unlock()
+			if err == nil {
+				r = withAPIToken(r, t, acct)
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		c, err := r.Cookie(sessionCookie)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		dbMu.Lock()
+		acct, err := sessionUser(db, c.Value)
+		dbMu.Unlock()
+		if err == nil {
+			r = r.WithContext(context.WithValue(r.Context(), accountKey{}, acct))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// accountOf returns the user who made the request, if signed in.
+func accountOf(r *http.Request) (account, bool) {
+	acct, ok := r.Context().Value(accountKey{}).(account)
+	return acct, ok
+}
+
+// roleOf returns the role of the user in the project, or an empty role when
+// the user is not a member.
+func roleOf(q queryer, userID, pID int64) (role, error) {
+	var r role
+	err := q.QueryRow("select role from members where project = ? and user = ?", pID, userID).Scan(&r)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return r, err
+}
+
+// checkRole returns the role of the user making the request if it covers
+// need, errSignedOut if nobody is signed in, or errForbidden.
+func checkRole(q queryer, r *http.Request, pID int64, need role) (role, error) {
+	acct, ok := accountOf(r)
+	if !ok {
+		return "", errSignedOut
+	}
+	if scope, ok := tokenProject(r); ok && scope != pID {
+		return "", errTokenScope
+	}
+	have, err := roleOf(q, acct.ID, pID)
+	if err != nil {
+		return "", err
+	}
+	if !have.allows(need) {
+		return "", errForbidden
+	}
+	return have, nil
+}
+
+// authorize checks that the user making the request has at least the role
+// need in the project. A share link stands for a viewer. When it returns
+// false, the error response has already been written: signed out users are
+// sent to the sign in page.
+func authorize(w http.ResponseWriter, r *http.Request, q queryer, pID int64, need role) (role, bool) {
+	var (
+		have role
+		err  error
+	)
+	if token := r.URL.Query().Get("share"); token != "" && need == viewer {
+		have, err = checkShare(q, pID, token)
+	} else {
+		have, err = checkRole(q, r, pID, need)
+	}
+	switch {
+	case errors.Is(err, errShareNotFound):
+		http.Error(w, http.StatusText(http.StatusNotFound)+":"+err.Error(), http.StatusNotFound)
+	case errors.Is(err, errSignedOut):
+		signIn(w, r)
+	case errors.Is(err, errForbidden), errors.Is(err, errTokenScope):
+		http.Error(w, http.StatusText(http.StatusForbidden)+":"+err.Error(), http.StatusForbidden)
+	case err != nil:
+		http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
+	default:
+		return have, true
+	}
+	return "", false
+}
+
+// signedIn returns the user making the request, sending them to the sign in
+// page when there is none. It takes a session: API tokens cannot make
+// projects or other tokens, lest a token outlive its expiry through them.
+func signedIn(w http.ResponseWriter, r *http.Request) (account, bool) {
+	acct, ok := accountOf(r)
+	if !ok {
+		signIn(w, r)
+		return account{}, false
+	}
+	if viaAPIToken(r) {
+		http.Error(w, http.StatusText(http.StatusForbidden)+":"+errNeedSession.Error(), http.StatusForbidden)
+		return account{}, false
+	}
+	return acct, true
+}
+
+// signIn sends the user to the sign in page, to come back afterwards.
+func signIn(w http.ResponseWriter, r *http.Request) {
+	to := "/login?next=" + url.QueryEscape(r.URL.RequestURI())
+	if r.Header.Get("HX-Request") != "" {
+		w.Header().Set("HX-Redirect", to)
+		http.Error(w, http.StatusText(http.StatusUnauthorized)+":"+errSignedOut.Error(), http.StatusUnauthorized)
+		return
+	}
+	if _, bearer := bearerToken(r); bearer {
+		http.Error(w, http.StatusText(http.StatusUnauthorized)+":"+errTokenNotFound.Error(), http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusUnauthorized)+":"+errSignedOut.Error(), http.StatusUnauthorized)
+		return
+	}
+	http.Redirect(w, r, to, http.StatusSeeOther)
+}
+
+// localRedirect keeps redirects after signing in within the site.
+func localRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+// member is a user's role in a project.
+type member struct {
+	account
+	Role role `json:"role"`
+}
+
+func listMembers(q queryer, pID int64) ([]member, error) {
+	rows, err := q.Query(`
+		select users.id, users.name, members.role from members join users on users.id = members.user
+			where members.project = ? order by users.name
+	`, pID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	members := []member{}
+	for rows.Next() {
+		var m member
+		if err := rows.Scan(&m.ID, &m.Name, &m.Role); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+// setMember gives the user a role in the project, keeping at least one
+// owner.
+func setMember(q queryer, pID, userID int64, r role) error {
+	if r != owner {
+		if err := keepOwner(q, pID, userID); err != nil {
+			return err
+		}
+	}
+	_, err := q.Exec(`
+		insert into members (project, user, role) values (?, ?, ?)
+			on conflict (project, user) do update set role = excluded.role
+	`, pID, userID, r)
+	return err
+}
+
+// removeMember takes the user out of the project, keeping at least one
+// owner.
+func removeMember(q queryer, pID, userID int64) error {
+	if err := keepOwner(q, pID, userID); err != nil {
+		return err
+	}
+	_, err := q.Exec("delete from members where project = ? and user = ?", pID, userID)
+	return err
+}
+
+// keepOwner fails if the user is the last owner of the project.
+func keepOwner(q queryer, pID, userID int64) error {
+	var others bool
+	err := q.QueryRow("select count(*) > 0 from members where project = ? and role = ? and user != ?", pID, owner, userID).Scan(&others)
+	if err != nil {
+		return err
+	}
+	current, err := roleOf(q, userID, pID)
+	if err != nil {
+		return err
+	}
+	if current == owner && !others {
+		return errLastOwner
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAuthorize(t *testing.T) {
+	db := newTestDB(t)
+	p1 := newTestProject(t, db)
+	p2 := newTestProject(t, db)
+	users := make(map[string]account)
+	for _, name := range []string{"ann", "ed", "vic", "out"} {
+		acct, err := createUser(db, name, "secret123")
+		if err != nil {
+			t.Fatal(err)
+		}
+		users[name] = acct
+	}
+	for _, m := range []struct {
+		pID  int64
+		user string
+		role role
+	}{
+		{p1, "ann", owner},
+		{p1, "ed", editor},
+		{p1, "vic", viewer},
+		{p2, "ann", owner},
+	} {
+		if err := setMember(db, m.pID, users[m.user].ID, m.role); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sessions := make(map[string]*http.Cookie)
+	for name, acct := range users {
+		c, err := startSession(db, httptest.NewRequest("GET", "/", nil), acct.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sessions[name] = c
+	}
+	allProjects, err := createAPIToken(db, users["ann"].ID, 0, "all", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	onlyP2, err := createAPIToken(db, users["ann"].ID, p2, "p2", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, shareP1, err := createShare(db, p1, "board")
+	if err != nil {
+		t.Fatal(err)
+	}
+	revoked, revokedToken, err := createShare(db, p1, "old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := revokeShare(db, p1, revoked.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		session string
+		bearer  string
+		share   string
+		pID     int64
+		need    role
+		status  int
+		have    role
+	}{
+		{name: "signed out", pID: p1, need: viewer, status: http.StatusSeeOther},
+		{name: "owner views", session: "ann", pID: p1, need: viewer, status: http.StatusOK, have: owner},
+		{name: "owner manages", session: "ann", pID: p1, need: owner, status: http.StatusOK, have: owner},
+		{name: "editor edits", session: "ed", pID: p1, need: editor, status: http.StatusOK, have: editor},
+		{name: "editor cannot manage", session: "ed", pID: p1, need: owner, status: http.StatusForbidden},
+		{name: "viewer views", session: "vic", pID: p1, need: viewer, status: http.StatusOK, have: viewer},
+		{name: "viewer cannot edit", session: "vic", pID: p1, need: editor, status: http.StatusForbidden},
+		{name: "not a member", session: "out", pID: p1, need: viewer, status: http.StatusForbidden},
+		{name: "member of another project", session: "ed", pID: p2, need: viewer, status: http.StatusForbidden},
+		{name: "share link views", share: shareP1, pID: p1, need: viewer, status: http.StatusOK, have: viewer},
+		{name: "share link cannot edit", share: shareP1, pID: p1, need: editor, status: http.StatusSeeOther},
+		{name: "share link of another project", share: shareP1, pID: p2, need: viewer, status: http.StatusNotFound},
+		{name: "revoked share link", share: revokedToken, pID: p1, need: viewer, status: http.StatusNotFound},
+		{name: "unknown share link", share: "nope", pID: p1, need: viewer, status: http.StatusNotFound},
+		{name: "token for all projects", bearer: allProjects, pID: p1, need: owner, status: http.StatusOK, have: owner},
+		{name: "project token in its project", bearer: onlyP2, pID: p2, need: editor, status: http.StatusOK, have: owner},
+		{name: "project token elsewhere", bearer: onlyP2, pID: p1, need: viewer, status: http.StatusForbidden},
+		{name: "unknown token", bearer: "nope", pID: p1, need: viewer, status: http.StatusUnauthorized},
+	}
+	var dbMu sync.Mutex
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := withSession(db, &dbMu, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if have, ok := authorize(w, r, db, tt.pID, tt.need); ok {
+					fmt.Fprint(w, have)
+				}
+			}))
+			r := httptest.NewRequest("GET", fmt.Sprintf("/projects?pID=%v&share=%v", tt.pID, tt.share), nil)
+			if tt.session != "" {
+				r.AddCookie(sessions[tt.session])
+			}
+			if tt.bearer != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.bearer)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("status = %v, want %v: %s", w.Code, tt.status, w.Body)
+			}
+			if tt.status == http.StatusOK && w.Body.String() != string(tt.have) {
+				t.Errorf("role = %q, want %q", w.Body, tt.have)
+			}
+		})
+	}
+}
+
+func TestClaimProjects(t *testing.T) {
+	db := newTestDB(t)
+	pID := newTestProject(t, db)
+	first, err := createUser(db, "first", "secret123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, err := roleOf(db, first.ID, pID); err != nil || have != "" {
+		t.Fatalf("the first user got role %q (%v) without claiming", have, err)
+	}
+	if n, err := unclaimedProjects(db); err != nil || n != 1 {
+		t.Fatalf("unclaimedProjects = %v, %v, want 1", n, err)
+	}
+	if n, err := claimProjects(db, first.ID); err != nil || n != 1 {
+		t.Fatalf("claimProjects = %v, %v, want 1", n, err)
+	}
+	if have, err := roleOf(db, first.ID, pID); err != nil || have != owner {
+		t.Errorf("role after claiming = %q, %v, want %q", have, err, owner)
+	}
+	if n, err := claimProjects(db, first.ID); err != nil || n != 0 {
+		t.Errorf("claiming again = %v, %v, want 0", n, err)
+	}
+}
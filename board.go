@@ -0,0 +1,44 @@
+package main
+
+// boardColumn is a column of the Kanban board: the bubbles in one state.
+type boardColumn struct {
+	State bubbleState
+	Cards []boardCard
+}
+
+// boardCard is a bubble on the Kanban board. Pending bubbles are either
+// ready to start or blocked by the predecessors listed in Blockers.
+type boardCard struct {
+	Bubble   string
+	Ready    bool
+	Blockers []string
+	Duration float64
+}
+
+// buildBoard groups the project's bubbles by state, in the order of
+// bubbleStates.
+func buildBoard(state projectState) []boardColumn {
+	ready := make(map[string]bool)
+	for _, bubble := range readyBubbles(state.Pairs, state.States, state.Project.AbortedUnblocks) {
+		ready[bubble.Bubble] = true
+	}
+	columns := make([]boardColumn, len(bubbleStates))
+	index := make(map[bubbleState]int)
+	for i, s := range bubbleStates {
+		columns[i].State = s
+		index[s] = i
+	}
+	for _, bubble := range knownBubbles(state.Pairs, state.States) {
+		card := boardCard{
+			Bubble:   bubble.Bubble,
+			Ready:    ready[bubble.Bubble],
+			Duration: state.Durations[bubble.Bubble],
+		}
+		if bubble.State == initial || bubble.State == started {
+			card.Blockers = blockersOf(state.Pairs, state.States, bubble.Bubble, state.Project.AbortedUnblocks)
+		}
+		i := index[bubble.State]
+		columns[i].Cards = append(columns[i].Cards, card)
+	}
+	return columns
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// envPrefix starts the name of the environment variable of each flag: the
+// flag -log-level is read from BUBBLES_LOG_LEVEL.
+const envPrefix = "BUBBLES_"
+
+// loadSettings fills the flags of fs that were not given on the command
+// line from the environment and then from the config file named by the
+// -config flag, if any. The command line wins over the environment, which
+// wins over the file.
+//
+// The config file has one "name = value" per line, named as the flags;
+// blank lines and lines starting with # are skipped.
+func loadSettings(fs *flag.FlagSet) error {
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		v, ok := os.LookupEnv(envName(f.Name))
+		if err != nil || given[f.Name] || !ok {
+			return
+		}
+		if setErr := fs.Set(f.Name, v); setErr != nil {
+			err = fmt.Errorf("%v: %w", envName(f.Name), setErr)
+		}
+		given[f.Name] = true
+	})
+	if err != nil {
+		return err
+	}
+	var configPath string
+	if f := fs.Lookup("config"); f != nil {
+		configPath = f.Value.String()
+	}
+	if configPath == "" {
+		return nil
+	}
+	values, err := readConfigFile(configPath)
+	if err != nil {
+		return err
+	}
+	for _, kv := range values {
+		if fs.Lookup(kv.name) == nil {
+			return fmt.Errorf("%v:%v: unknown setting %q", configPath, kv.line, kv.name)
+		}
+		if kv.name == "config" {
+			return fmt.Errorf("%v:%v: config files cannot include other config files", configPath, kv.line)
+		}
+		if given[kv.name] {
+			continue
+		}
+		if err := fs.Set(kv.name, kv.value); err != nil {
+			return fmt.Errorf("%v:%v: %v: %w", configPath, kv.line, kv.name, err)
+		}
+	}
+	return nil
+}
+
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+type configValue struct {
+	line        int
+	name, value string
+}
+
+func readConfigFile(path string) ([]configValue, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var values []configValue
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%v:%v: expected name = value", path, n)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+			value = value[1 : len(value)-1]
+		}
+		values = append(values, configValue{line: n, name: strings.TrimSpace(name), value: value})
+	}
+	return values, scanner.Err()
+}
+
+// logLevel filters what the server logs. Errors are always logged.
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var logLevels = map[string]logLevel{"debug": levelDebug, "info": levelInfo, "warn": levelWarn, "error": levelError}
+
+// currentLogLevel is set once, from the -log-level flag, before the server
+// starts.
+var currentLogLevel = levelInfo
+
+func parseLogLevel(s string) (logLevel, error) {
+	if l, ok := logLevels[strings.ToLower(s)]; ok {
+		return l, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q: use debug, info, warn or error", s)
+}
+
+func debugf(format string, args ...any) { logAt(levelDebug, format, args...) }
+func infof(format string, args ...any)  { logAt(levelInfo, format, args...) }
+func warnf(format string, args ...any)  { logAt(levelWarn, format, args...) }
+
+func logAt(l logLevel, format string, args ...any) {
+	if l >= currentLogLevel {
+		log.Printf(format, args...)
+	}
+}
+
+// withRequestLog logs every request at the debug level.
+func withRequestLog(next http.Handler) http.Handler {
+	if currentLogLevel > levelDebug {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		debugf("%v %v %v %v (%v)", r.Method, r.URL.RequestURI(), rec.status, actorOf(r), time.Since(start).Round(time.Millisecond))
+	})
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+// Flush keeps server-sent events streaming through the recorder.
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
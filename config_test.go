@@ -0,0 +1,140 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadSettings(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		file string
+		want map[string]string
+		err  string
+	}{
+		{
+			name: "defaults",
+			want: map[string]string{"listen": "localhost:5466", "log-level": "info", "render-cache": "128"},
+		},
+		{
+			name: "file",
+			file: "# comment\n\nlisten = 0.0.0.0:80\nlog-level = \"debug\"\n",
+			want: map[string]string{"listen": "0.0.0.0:80", "log-level": "debug", "render-cache": "128"},
+		},
+		{
+			name: "environment over file",
+			env:  map[string]string{"BUBBLES_LISTEN": ":8080", "BUBBLES_RENDER_CACHE": "0"},
+			file: "listen = 0.0.0.0:80\nlog-level = warn\n",
+			want: map[string]string{"listen": ":8080", "log-level": "warn", "render-cache": "0"},
+		},
+		{
+			name: "command line over environment and file",
+			args: []string{"-listen", ":9090"},
+			env:  map[string]string{"BUBBLES_LISTEN": ":8080", "BUBBLES_LOG_LEVEL": "error"},
+			file: "listen = 0.0.0.0:80\nlog-level = warn\nrender-cache = 5\n",
+			want: map[string]string{"listen": ":9090", "log-level": "error", "render-cache": "5"},
+		},
+		{
+			name: "invalid environment value",
+			env:  map[string]string{"BUBBLES_RENDER_CACHE": "many"},
+			err:  "BUBBLES_RENDER_CACHE: ",
+		},
+		{
+			name: "invalid file value",
+			file: "listen = :80\nrender-cache = many\n",
+			err:  "settings.conf:2: render-cache: ",
+		},
+		{
+			name: "unknown setting",
+			file: "colour = red\n",
+			err:  `settings.conf:1: unknown setting "colour"`,
+		},
+		{
+			name: "nested config",
+			file: "config = other.conf\n",
+			err:  "settings.conf:1: config files cannot include other config files",
+		},
+		{
+			name: "missing equals sign",
+			file: "\nlisten :80\n",
+			err:  "settings.conf:2: expected name = value",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("bubbles", flag.ContinueOnError)
+			fs.SetOutput(io.Discard)
+			fs.String("config", "", "")
+			fs.String("listen", "localhost:5466", "")
+			fs.String("log-level", "info", "")
+			fs.Int("render-cache", 128, "")
+			args := tt.args
+			if tt.file != "" {
+				path := filepath.Join(t.TempDir(), "settings.conf")
+				if err := os.WriteFile(path, []byte(tt.file), 0o600); err != nil {
+					t.Fatal(err)
+				}
+				args = append([]string{"-config", path}, args...)
+			}
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			if err := fs.Parse(args); err != nil {
+				t.Fatal(err)
+			}
+			err := loadSettings(fs)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("loadSettings = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, want := range tt.want {
+				if got := fs.Lookup(name).Value.String(); got != want {
+					t.Errorf("%v = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	for flagName, want := range map[string]string{
+		"db":                  "BUBBLES_DB",
+		"log-level":           "BUBBLES_LOG_LEVEL",
+		"webhook-allow-local": "BUBBLES_WEBHOOK_ALLOW_LOCAL",
+	} {
+		if got := envName(flagName); got != want {
+			t.Errorf("envName(%q) = %q, want %q", flagName, got, want)
+		}
+	}
+}
+
+func TestParseLogLevel(t *testing.T) {
+	tests := []struct {
+		s    string
+		want logLevel
+		ok   bool
+	}{
+		{"debug", levelDebug, true},
+		{"INFO", levelInfo, true},
+		{"warn", levelWarn, true},
+		{"error", levelError, true},
+		{"verbose", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseLogLevel(tt.s)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("parseLogLevel(%q) = %v, %v, want %v", tt.s, got, err, tt.want)
+		}
+	}
+}
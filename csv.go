@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+)
+
+var (
+	pairsCSVHeader   = []string{"left", "right"}
+	bubblesCSVHeader = []string{"bubble", "state"}
+)
+
+func writePairsCSV(deps []dep) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w := csv.NewWriter(buf)
+	if err := w.Write(pairsCSVHeader); err != nil {
+		return nil, err
+	}
+	for _, dep := range deps {
+		if err := w.Write([]string{dep.Left, dep.Right}); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+func writeBubblesCSV(bubbles []bubble) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	w := csv.NewWriter(buf)
+	if err := w.Write(bubblesCSVHeader); err != nil {
+		return nil, err
+	}
+	for _, bubble := range bubbles {
+		if err := w.Write([]string{bubble.Bubble, string(bubble.State)}); err != nil {
+			return nil, err
+		}
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// readCSV calls fn with every two-column record and the line it starts on.
+// A header row, as written by the export, is skipped.
+func readCSV(r io.Reader, header []string, fn func(line int, a, b string) error) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 2
+	cr.TrimLeadingSpace = true
+	for first := true; ; first = false {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if first && strings.EqualFold(record[0], header[0]) && strings.EqualFold(record[1], header[1]) {
+			continue
+		}
+		line, _ := cr.FieldPos(0)
+		a, b := strings.TrimSpace(record[0]), strings.TrimSpace(record[1])
+		if a == "" || b == "" {
+			return fmt.Errorf("line %d: empty field", line)
+		}
+		if err := fn(line, a, b); err != nil {
+			return err
+		}
+	}
+}
+
+func readPairsCSV(r io.Reader) ([]dep, error) {
+	deps := []dep{}
+	seen := make(map[dep]bool)
+	err := readCSV(r, pairsCSVHeader, func(_ int, left, right string) error {
+		if dep := (dep{Left: left, Right: right}); !seen[dep] {
+			seen[dep] = true
+			deps = append(deps, dep)
+		}
+		return nil
+	})
+	return deps, err
+}
+
+func readBubblesCSV(r io.Reader) (map[string]bubbleState, error) {
+	states := make(map[string]bubbleState)
+	err := readCSV(r, bubblesCSVHeader, func(line int, name, s string) error {
+		state, err := parseBubbleState(s)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		states[name] = state
+		return nil
+	})
+	return states, err
+}
+
+// csvImport is the content of uploaded CSV files. Sections that were not
+// uploaded are nil.
+type csvImport struct {
+	Pairs  []dep
+	States map[string]bubbleState
+}
+
+// planCSVImport returns the state the project would have after the import.
+// Merging adds the uploaded pairs and overrides the states of the uploaded
+// bubbles; replacing swaps each uploaded section for the project's own.
+func planCSVImport(current projectState, in csvImport, replace bool) (projectState, error) {
+	target := projectState{
+		Project: current.Project,
+		Pairs:   slices.Clone(current.Pairs),
+		States:  maps.Clone(current.States),
+	}
+	if in.Pairs != nil {
+		if replace {
+			target.Pairs = nil
+		}
+		seen := make(map[dep]bool)
+		for _, dep := range target.Pairs {
+			seen[dep] = true
+		}
+		for _, dep := range in.Pairs {
+			if !seen[dep] {
+				seen[dep] = true
+				target.Pairs = append(target.Pairs, dep)
+			}
+		}
+	}
+	if in.States != nil {
+		if replace {
+			target.States = make(map[string]bubbleState)
+		}
+		maps.Copy(target.States, in.States)
+	}
+	// Report the first pair that is part of a loop, with the way back from
+	// its right bubble to its left one.
+	loops := cycleEdges(target.Pairs)
+	for _, dep := range target.Pairs {
+		if loops[dep] {
+			path := findPath(target.Pairs, dep.Right, dep.Left)
+			return projectState{}, &cycleError{Path: append([]string{dep.Left}, path...)}
+		}
+	}
+	return target, nil
+}
+
+// importCSV applies the CSV files to the project and returns what changed.
+func importCSV(j *journal, pID int64, in csvImport, replace bool) (projectDiff, error) {
+	current, err := captureState(j, pID)
+	if err != nil {
+		return projectDiff{}, err
+	}
+	target, err := planCSVImport(current, in, replace)
+	if err != nil {
+		return projectDiff{}, err
+	}
+	return diffStates(current, target), restoreState(j, pID, target)
+}
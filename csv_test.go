@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCSVRoundTrip(t *testing.T) {
+	deps := []dep{
+		{Left: "design", Right: "build"},
+		{Left: "build", Right: "ship, then celebrate"},
+		{Left: `say "hi"`, Right: "café → 日本"},
+	}
+	out, err := writePairsCSV(deps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotDeps, err := readPairsCSV(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(gotDeps, deps) {
+		t.Errorf("pairs = %q, want %q\n%s", gotDeps, deps, out)
+	}
+
+	bubbles := []bubble{
+		{Bubble: "design", State: done},
+		{Bubble: "build", State: started},
+		{Bubble: "ship, then celebrate", State: initial},
+		{Bubble: `say "hi"`, State: aborted},
+	}
+	out, err = writeBubblesCSV(bubbles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotStates, err := readBubblesCSV(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := make(map[string]bubbleState)
+	for _, b := range bubbles {
+		want[b.Bubble] = b.State
+	}
+	if !maps.Equal(gotStates, want) {
+		t.Errorf("states = %v, want %v\n%s", gotStates, want, out)
+	}
+}
+
+func TestReadPairsCSV(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []dep
+		err  string
+	}{
+		{"header", "left,right\na,b\n", []dep{{Left: "a", Right: "b"}}, ""},
+		{"no header", "a,b\nb,c\n", []dep{{Left: "a", Right: "b"}, {Left: "b", Right: "c"}}, ""},
+		{"header in any case", "Left,RIGHT\na,b\n", []dep{{Left: "a", Right: "b"}}, ""},
+		{"spaces and repeats", " a , b\na,b\n", []dep{{Left: "a", Right: "b"}}, ""},
+		{"empty", "", []dep{}, ""},
+		{"empty field", "left,right\na,b\nc, \n", nil, "line 3: empty field"},
+		{"three fields", "a,b,c\n", nil, "wrong number of fields"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readPairsCSV(strings.NewReader(tt.src))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("readPairsCSV(%q) = %v, want %q", tt.src, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("readPairsCSV(%q) = %q, want %q", tt.src, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadBubblesCSVErrors(t *testing.T) {
+	if _, err := readBubblesCSV(strings.NewReader("bubble,state\na,done\nb,finished\n")); err == nil || !strings.HasPrefix(err.Error(), "line 3: ") {
+		t.Errorf("readBubblesCSV = %v, want an error on line 3", err)
+	}
+}
+
+func TestPlanCSVImport(t *testing.T) {
+	current := projectState{
+		Project: project{ID: 1, Name: "plan"},
+		Pairs:   []dep{{Left: "a", Right: "b"}},
+		States:  map[string]bubbleState{"a": done},
+	}
+	tests := []struct {
+		name    string
+		in      csvImport
+		replace bool
+		pairs   []dep
+		states  map[string]bubbleState
+		cycle   []string
+	}{
+		{
+			name:   "nothing uploaded",
+			pairs:  []dep{{Left: "a", Right: "b"}},
+			states: map[string]bubbleState{"a": done},
+		},
+		{
+			name:   "merge pairs",
+			in:     csvImport{Pairs: []dep{{Left: "b", Right: "c"}, {Left: "a", Right: "b"}}},
+			pairs:  []dep{{Left: "a", Right: "b"}, {Left: "b", Right: "c"}},
+			states: map[string]bubbleState{"a": done},
+		},
+		{
+			name:    "replace pairs",
+			in:      csvImport{Pairs: []dep{{Left: "x", Right: "y"}}},
+			replace: true,
+			pairs:   []dep{{Left: "x", Right: "y"}},
+			states:  map[string]bubbleState{"a": done},
+		},
+		{
+			name:   "merge states",
+			in:     csvImport{States: map[string]bubbleState{"b": started}},
+			pairs:  []dep{{Left: "a", Right: "b"}},
+			states: map[string]bubbleState{"a": done, "b": started},
+		},
+		{
+			name:    "replace states",
+			in:      csvImport{States: map[string]bubbleState{"b": started}},
+			replace: true,
+			pairs:   []dep{{Left: "a", Right: "b"}},
+			states:  map[string]bubbleState{"b": started},
+		},
+		{
+			name:    "replace both",
+			in:      csvImport{Pairs: []dep{}, States: map[string]bubbleState{}},
+			replace: true,
+			states:  map[string]bubbleState{},
+		},
+		{
+			name:  "merging closes a loop",
+			in:    csvImport{Pairs: []dep{{Left: "b", Right: "c"}, {Left: "c", Right: "a"}}},
+			cycle: []string{"a", "b", "c", "a"},
+		},
+		{
+			name:    "loop in the file",
+			in:      csvImport{Pairs: []dep{{Left: "x", Right: "y"}, {Left: "y", Right: "x"}}},
+			replace: true,
+			cycle:   []string{"x", "y", "x"},
+		},
+		{
+			name:  "self loop",
+			in:    csvImport{Pairs: []dep{{Left: "c", Right: "c"}}},
+			cycle: []string{"c", "c"},
+		},
+		{
+			// The pair reversed in the file no longer forms a loop once the
+			// old one is gone.
+			name:    "replacing reverses a pair",
+			in:      csvImport{Pairs: []dep{{Left: "b", Right: "a"}}},
+			replace: true,
+			pairs:   []dep{{Left: "b", Right: "a"}},
+			states:  map[string]bubbleState{"a": done},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := planCSVImport(current, tt.in, tt.replace)
+			if tt.cycle != nil {
+				var cycleErr *cycleError
+				if !errors.As(err, &cycleErr) {
+					t.Fatalf("planCSVImport = %v, want a cycle error", err)
+				}
+				if !slices.Equal(cycleErr.Path, tt.cycle) {
+					t.Errorf("cycle = %v, want %v", cycleErr.Path, tt.cycle)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got.Pairs, tt.pairs) {
+				t.Errorf("pairs = %v, want %v", got.Pairs, tt.pairs)
+			}
+			if !maps.Equal(got.States, tt.states) {
+				t.Errorf("states = %v, want %v", got.States, tt.states)
+			}
+			if got.Project != current.Project {
+				t.Errorf("project = %+v, want %+v", got.Project, current.Project)
+			}
+		})
+	}
+	if len(current.Pairs) != 1 || len(current.States) != 1 {
+		t.Errorf("planning changed the current state: %+v", current)
+	}
+}
+
+func TestImportCSV(t *testing.T) {
+	db := newTestDB(t)
+	pID := newTestProject(t, db, dep{Left: "a", Right: "b"})
+	steps := []struct {
+		name    string
+		pairs   string
+		replace bool
+		want    []dep
+		added   []dep
+		removed []dep
+	}{
+		{"merge", "b,c\n", false, []dep{{Left: "a", Right: "b"}, {Left: "b", Right: "c"}}, []dep{{Left: "b", Right: "c"}}, nil},
+		{"merge again", "a,b\nb,c\n", false, []dep{{Left: "a", Right: "b"}, {Left: "b", Right: "c"}}, nil, nil},
+		{"replace", "x,y\n", true, []dep{{Left: "x", Right: "y"}}, []dep{{Left: "x", Right: "y"}}, []dep{{Left: "a", Right: "b"}, {Left: "b", Right: "c"}}},
+	}
+	for _, step := range steps {
+		in := csvImport{}
+		var err error
+		if in.Pairs, err = readPairsCSV(strings.NewReader(step.pairs)); err != nil {
+			t.Fatal(err)
+		}
+		var diff projectDiff
+		edit(t, db, func(j *journal) error {
+			diff, err = importCSV(j, pID, in, step.replace)
+			return err
+		})
+		deps, _, err := loadGraph(db, pID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		slices.SortFunc(deps, compareDeps)
+		if !slices.Equal(deps, step.want) {
+			t.Errorf("%v: pairs = %v, want %v", step.name, deps, step.want)
+		}
+		slices.SortFunc(diff.AddedPairs, compareDeps)
+		slices.SortFunc(diff.RemovedPairs, compareDeps)
+		if !slices.Equal(diff.AddedPairs, step.added) || !slices.Equal(diff.RemovedPairs, step.removed) {
+			t.Errorf("%v: added %v and removed %v, want %v and %v", step.name, diff.AddedPairs, diff.RemovedPairs, step.added, step.removed)
+		}
+	}
+}
+
+func compareDeps(a, b dep) int {
+	if c := strings.Compare(a.Left, b.Left); c != 0 {
+		return c
+	}
+	return strings.Compare(a.Right, b.Right)
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// cycleError reports a pair that would close a loop in the project graph.
+type cycleError struct {
+	Path []string
+}
+
+func (e *cycleError) Error() string {
+	return "pair would create a cycle: " + strings.Join(e.Path, " -> ")
+}
+
+func successors(deps []dep) map[string][]string {
+	next := make(map[string][]string)
+	for _, dep := range deps {
+		next[dep.Left] = append(next[dep.Left], dep.Right)
+	}
+	return next
+}
+
+// findPath returns the shortest path from one bubble to another following
+// the direction of the pairs, or nil if there is none.
+func findPath(deps []dep, from, to string) []string {
+	next := successors(deps)
+	parent := map[string]string{from: ""}
+	queue := []string{from}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		if cur == to {
+			var path []string
+			for n := to; n != from; n = parent[n] {
+				path = append(path, n)
+			}
+			path = append(path, from)
+			for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+				path[i], path[j] = path[j], path[i]
+			}
+			return path
+		}
+		for _, n := range next[cur] {
+			if _, seen := parent[n]; !seen {
+				parent[n] = cur
+				queue = append(queue, n)
+			}
+		}
+	}
+	return nil
+}
+
+// checkAcyclic returns a *cycleError if adding left -> right to deps would
+// close a loop.
+func checkAcyclic(deps []dep, left, right string) error {
+	if left == right {
+		return &cycleError{Path: []string{left, right}}
+	}
+	path := findPath(deps, right, left)
+	if path == nil {
+		return nil
+	}
+	return &cycleError{Path: append([]string{left}, path...)}
+}
+
+// findCycles groups the bubbles that take part in loops, using Tarjan's
+// strongly connected components algorithm. Each group, and the list of
+// groups, is sorted.
+func findCycles(deps []dep) [][]string {
+	next := successors(deps)
+	selfLoop := make(map[string]bool)
+	var nodes []string
+	seen := make(map[string]bool)
+	for _, dep := range deps {
+		for _, n := range []string{dep.Left, dep.Right} {
+			if !seen[n] {
+				seen[n] = true
+				nodes = append(nodes, n)
+			}
+		}
+		if dep.Left == dep.Right {
+			selfLoop[dep.Left] = true
+		}
+	}
+	sort.Strings(nodes)
+
+	var (
+		index   = make(map[string]int)
+		lowlink = make(map[string]int)
+		onStack = make(map[string]bool)
+		stack   []string
+		counter int
+		groups  [][]string
+	)
+	var connect func(v string)
+	connect = func(v string) {
+		index[v] = counter
+		lowlink[v] = counter
+		counter++
+		stack = append(stack, v)
+		onStack[v] = true
+		for _, w := range next[v] {
+			if _, visited := index[w]; !visited {
+				connect(w)
+				lowlink[v] = min(lowlink[v], lowlink[w])
+			} else if onStack[w] {
+				lowlink[v] = min(lowlink[v], index[w])
+			}
+		}
+		if lowlink[v] != index[v] {
+			return
+		}
+		var group []string
+		for {
+			w := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[w] = false
+			group = append(group, w)
+			if w == v {
+				break
+			}
+		}
+		if len(group) > 1 || selfLoop[v] {
+			sort.Strings(group)
+			groups = append(groups, group)
+		}
+	}
+	for _, n := range nodes {
+		if _, visited := index[n]; !visited {
+			connect(n)
+		}
+	}
+	sort.Slice(groups, func(a, b int) bool {
+		return groups[a][0] < groups[b][0]
+	})
+	return groups
+}
+
+// cycleEdges returns the pairs that belong to at least one loop: those whose
+// ends are in the same strongly connected component.
+func cycleEdges(deps []dep) map[dep]bool {
+	component := make(map[string]int)
+	for i, group := range findCycles(deps) {
+		for _, n := range group {
+			component[n] = i + 1
+		}
+	}
+	edges := make(map[dep]bool)
+	for _, dep := range deps {
+		if c := component[dep.Left]; c != 0 && c == component[dep.Right] {
+			edges[dep] = true
+		}
+	}
+	return edges
+}
+
+// unblocks tells whether a bubble in the given state lets its successors
+// start.
+func unblocks(state bubbleState, abortedUnblocks bool) bool {
+	return state == done || (state == aborted && abortedUnblocks)
+}
+
+// blockersOf lists, sorted, the predecessors of the bubble that still keep it
+// from starting.
+func blockersOf(deps []dep, states map[string]bubbleState, name string, abortedUnblocks bool) []string {
+	var blockers []string
+	for _, dep := range deps {
+		if dep.Right == name && !unblocks(states[dep.Left], abortedUnblocks) {
+			blockers = append(blockers, dep.Left)
+		}
+	}
+	sort.Strings(blockers)
+	return blockers
+}
+
+// readyBubbles lists the bubbles that can be started now: they are still
+// initial and every bubble upstream of them is done, or aborted when
+// abortedUnblocks is set.
+func readyBubbles(deps []dep, states map[string]bubbleState, abortedUnblocks bool) []bubble {
+	blocked := make(map[string]bool)
+	for _, dep := range deps {
+		if !unblocks(states[dep.Left], abortedUnblocks) {
+			blocked[dep.Right] = true
+		}
+	}
+	ready := []bubble{}
+	for _, bubble := range knownBubbles(deps, states) {
+		if bubble.State == initial && !blocked[bubble.Bubble] {
+			ready = append(ready, bubble)
+		}
+	}
+	return ready
+}
+
+// topoOrder sorts the bubbles of deps so that every pair goes from an
+// earlier bubble to a later one, breaking ties by name. It reports false if
+// the graph has a loop.
+func topoOrder(deps []dep) ([]string, bool) {
+	indegree := make(map[string]int)
+	for _, dep := range deps {
+		indegree[dep.Left] += 0
+		indegree[dep.Right]++
+	}
+	var queue []string
+	for n, d := range indegree {
+		if d == 0 {
+			queue = append(queue, n)
+		}
+	}
+	sort.Strings(queue)
+	next := successors(deps)
+	order := make([]string, 0, len(indegree))
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		order = append(order, cur)
+		var freed []string
+		for _, n := range next[cur] {
+			if indegree[n]--; indegree[n] == 0 {
+				freed = append(freed, n)
+			}
+		}
+		sort.Strings(freed)
+		queue = append(queue, freed...)
+	}
+	return order, len(order) == len(indegree)
+}
+
+// remaining is the work left in a bubble: its estimate, unless it is done.
+func remaining(state bubbleState, d float64) float64 {
+	if state == done {
+		return 0
+	}
+	return d
+}
+
+// remainingWork adds up the estimates of the bubbles that are not done.
+func remainingWork(deps []dep, states map[string]bubbleState, durations map[string]float64) float64 {
+	total := 0.0
+	for _, bubble := range knownBubbles(deps, states) {
+		total += remaining(bubble.State, durations[bubble.Bubble])
+	}
+	return total
+}
+
+// criticalPath returns the chain of bubbles with the most work left, and
+// how much that is. Done bubbles have no work left. There is no critical
+// path in graphs with loops or without any work left.
+func criticalPath(deps []dep, states map[string]bubbleState, durations map[string]float64) ([]string, float64) {
+	order, ok := topoOrder(deps)
+	if !ok {
+		return nil, 0
+	}
+	preds := make(map[string][]string)
+	for _, dep := range deps {
+		preds[dep.Right] = append(preds[dep.Right], dep.Left)
+	}
+	finish := make(map[string]float64)
+	via := make(map[string]string)
+	var (
+		end  string
+		best float64
+	)
+	for _, n := range order {
+		start := 0.0
+		for _, p := range preds[n] {
+			if finish[p] > start || (finish[p] == start && via[n] != "" && p < via[n]) {
+				start, via[n] = finish[p], p
+			}
+		}
+		finish[n] = start + remaining(states[n], durations[n])
+		if finish[n] > best {
+			end, best = n, finish[n]
+		}
+	}
+	if best == 0 {
+		return nil, 0
+	}
+	var path []string
+	for n := end; n != ""; n = via[n] {
+		path = append(path, n)
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path, best
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"maps"
+	"slices"
+	"strings"
+)
+
+type dotOptions struct {
+	Vertical bool
+
+	// Base, when set, turns the graph into a diff against an earlier state
+	// of the project: pairs added since then are green, removed pairs and
+	// bubbles are dashed red, and bubbles whose state changed have a double
+	// border.
+	Base *projectState
+
+	// Durations are the estimates of the bubbles. When set, the critical
+	// path is drawn in bold.
+	Durations map[string]float64
+
+	// Info holds the fields of the bubbles, shown in their tooltips.
+	Info map[string]bubbleInfo
+
+	// Clusters groups the bubbles by tag, boxing each group.
+	Clusters bool
+
+	// Tags, when set, limits the graph to the bubbles with any of these
+	// tags and their immediate neighbors.
+	Tags []string
+
+	// ReadOnly leaves out the links that flip bubbles, for viewers.
+	ReadOnly bool
+}
+
+// buildDOT renders the project graph as Graphviz source. Every node links
+// to /flip, filled with the color of its state; bubbles ready to start are
+// outlined in blue, pairs that take part in a loop are drawn in red and the
+// critical path is bold. Tooltips show the fields of the bubbles and, in
+// strict projects, what a pending bubble waits for. Filtering by tag only
+// hides bubbles: readiness and the critical path are worked out on the
+// whole project.
+func buildDOT(p project, deps []dep, states map[string]bubbleState, opts dotOptions) string {
+	input := &bytes.Buffer{}
+	fmt.Fprintln(input, "digraph G {")
+	if !opts.Vertical {
+		fmt.Fprintln(input, `	rankdir="LR"`)
+	}
+	var diff projectDiff
+	if opts.Base != nil {
+		diff = diffStates(*opts.Base, projectState{Project: p, Pairs: deps, States: states})
+	}
+	inCycle := cycleEdges(deps)
+	critical := make(map[string]bool)
+	criticalEdge := make(map[dep]bool)
+	if opts.Base == nil && opts.Durations != nil {
+		path, _ := criticalPath(deps, states, opts.Durations)
+		for i, name := range path {
+			critical[name] = true
+			if i > 0 {
+				criticalEdge[dep{Left: path[i-1], Right: name}] = true
+			}
+		}
+	}
+	visible := tagSlice(deps, opts.Info, opts.Tags)
+	shown := func(name string) bool {
+		return visible == nil || visible[name]
+	}
+	for _, dep := range deps {
+		if !shown(dep.Left) || !shown(dep.Right) {
+			continue
+		}
+		switch {
+		case opts.Base != nil && diff.addedPair(dep):
+			fmt.Fprintf(input, "	%q -> %q [color=green,penwidth=2]\n", dep.Left, dep.Right)
+		case opts.Base == nil && inCycle[dep]:
+			fmt.Fprintf(input, "	%q -> %q [color=red,penwidth=2]\n", dep.Left, dep.Right)
+		case criticalEdge[dep]:
+			fmt.Fprintf(input, "	%q -> %q [penwidth=3]\n", dep.Left, dep.Right)
+		default:
+			fmt.Fprintf(input, "	%q -> %q\n", dep.Left, dep.Right)
+		}
+	}
+	for _, dep := range diff.RemovedPairs {
+		if !shown(dep.Left) || !shown(dep.Right) {
+			continue
+		}
+		fmt.Fprintf(input, "	%q -> %q [color=red,style=dashed]\n", dep.Left, dep.Right)
+	}
+	ready := make(map[string]bool)
+	if opts.Base == nil {
+		for _, bubble := range readyBubbles(deps, states, p.AbortedUnblocks) {
+			ready[bubble.Bubble] = true
+		}
+	}
+	changed := make(map[string]stateChange)
+	for _, change := range diff.ChangedStates {
+		changed[change.Bubble] = change
+	}
+	clusters := make(map[string]*bytes.Buffer)
+	for _, bubble := range knownBubbles(deps, states) {
+		if !shown(bubble.Bubble) {
+			continue
+		}
+		out := input
+		if tag := clusterTag(opts.Info[bubble.Bubble].Tags, opts.Tags); opts.Clusters && tag != "" {
+			if clusters[tag] == nil {
+				clusters[tag] = &bytes.Buffer{}
+			}
+			out = clusters[tag]
+			fmt.Fprint(out, "\t")
+		}
+		var attrs []string
+		if !opts.ReadOnly {
+			attrs = append(attrs, fmt.Sprintf(`href="/flip?pID=%v&bubble=%v"`, p.ID, template.URLQueryEscaper(bubble.Bubble)))
+		}
+		if color := bubble.State.color(); color != "" {
+			attrs = append(attrs, color)
+		}
+		if ready[bubble.Bubble] {
+			attrs = append(attrs, "color=blue")
+		}
+		switch {
+		case critical[bubble.Bubble]:
+			attrs = append(attrs, "penwidth=3")
+		case ready[bubble.Bubble]:
+			attrs = append(attrs, "penwidth=2")
+		}
+		var tooltip []string
+		if change, ok := changed[bubble.Bubble]; ok {
+			attrs = append(attrs, "peripheries=2")
+			tooltip = append(tooltip, fmt.Sprintf("was %v", change.Old))
+		} else if p.Strict && (bubble.State == initial || bubble.State == started) {
+			if blockers := blockersOf(deps, states, bubble.Bubble, p.AbortedUnblocks); len(blockers) > 0 {
+				tooltip = append(tooltip, "waiting for "+strings.Join(blockers, ", "))
+			}
+		}
+		tooltip = append(tooltip, opts.Info[bubble.Bubble].tooltip()...)
+		if len(tooltip) > 0 {
+			attrs = append(attrs, fmt.Sprintf("tooltip=%q", strings.Join(tooltip, "\n")))
+		}
+		fmt.Fprintf(out, "	%q [%v]\n", bubble.Bubble, strings.Join(attrs, ","))
+	}
+	for _, tag := range slices.Sorted(maps.Keys(clusters)) {
+		fmt.Fprintf(input, "	subgraph %q {\n", "cluster_"+tag)
+		fmt.Fprintf(input, "		label=%q\n", tag)
+		clusters[tag].WriteTo(input)
+		fmt.Fprintln(input, "	}")
+	}
+	for _, name := range diff.RemovedBubbles {
+		if !shown(name) {
+			continue
+		}
+		fmt.Fprintf(input, "	%q [color=red,fontcolor=red,style=dashed]\n", name)
+	}
+	fmt.Fprintln(input, "}")
+	return input.String()
+}
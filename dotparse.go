@@ -0,0 +1,433 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// dotGraph is a parsed Graphviz document. Nodes and edges keep the order in
+// which they first appear in the source.
+type dotGraph struct {
+	ID       string
+	Directed bool
+	Attrs    map[string]string
+	Nodes    []*dotNode
+	Edges    []*dotEdge
+	Clusters []*dotCluster
+
+	nodes map[string]*dotNode
+}
+
+type dotNode struct {
+	ID      string
+	Attrs   map[string]string
+	Cluster string
+}
+
+type dotEdge struct {
+	From, To string
+	Attrs    map[string]string
+}
+
+type dotCluster struct {
+	ID    string
+	Attrs map[string]string
+}
+
+func (g *dotGraph) node(id string, sc *dotScope) *dotNode {
+	if n, ok := g.nodes[id]; ok {
+		// As in Graphviz, a node declared before its cluster still joins
+		// it when the cluster mentions it.
+		if n.Cluster == "" {
+			n.Cluster = sc.cluster
+		}
+		return n
+	}
+	n := &dotNode{ID: id, Attrs: maps.Clone(sc.nodeAttrs), Cluster: sc.cluster}
+	g.nodes[id] = n
+	g.Nodes = append(g.Nodes, n)
+	return n
+}
+
+// parseDOT reads a graph in the Graphviz DOT language. It understands
+// node, edge and attribute statements, chained edges, subgraphs (including
+// subgraphs as edge endpoints) and clusters; ports are accepted and ignored.
+func parseDOT(src string) (*dotGraph, error) {
+	toks, err := lexDOT(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &dotParser{toks: toks}
+	return p.parseGraph()
+}
+
+type dotTokenKind int
+
+const (
+	dotEOF dotTokenKind = iota
+	dotID
+	dotPunct
+)
+
+type dotToken struct {
+	kind dotTokenKind
+	text string
+	line int
+}
+
+func lexDOT(src string) ([]dotToken, error) {
+	var toks []dotToken
+	line := 1
+	rs := []rune(src)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case r == '\n':
+			line++
+			i++
+		case unicode.IsSpace(r):
+			i++
+		case r == '#' && (i == 0 || rs[i-1] == '\n'), r == '/' && i+1 < len(rs) && rs[i+1] == '/':
+			for i < len(rs) && rs[i] != '\n' {
+				i++
+			}
+		case r == '/' && i+1 < len(rs) && rs[i+1] == '*':
+			i += 2
+			for i < len(rs) && !(rs[i] == '*' && i+1 < len(rs) && rs[i+1] == '/') {
+				if rs[i] == '\n' {
+					line++
+				}
+				i++
+			}
+			if i >= len(rs) {
+				return nil, fmt.Errorf("line %d: unterminated comment", line)
+			}
+			i += 2
+		case r == '-' && i+1 < len(rs) && (rs[i+1] == '>' || rs[i+1] == '-'):
+			toks = append(toks, dotToken{kind: dotPunct, text: string(rs[i : i+2]), line: line})
+			i += 2
+		case strings.ContainsRune("{}[]=;,:", r):
+			toks = append(toks, dotToken{kind: dotPunct, text: string(r), line: line})
+			i++
+		case r == '"':
+			start, startLine := i, line
+			i++
+			for i < len(rs) && rs[i] != '"' {
+				if rs[i] == '\\' && i+1 < len(rs) {
+					i++
+				}
+				if rs[i] == '\n' {
+					line++
+				}
+				i++
+			}
+			if i >= len(rs) {
+				return nil, fmt.Errorf("line %d: unterminated string", startLine)
+			}
+			i++
+			text := unquoteDOT(string(rs[start:i]))
+			// "a" + "b" concatenates strings.
+			if n := len(toks); n >= 2 && toks[n-1].kind == dotPunct && toks[n-1].text == "+" && toks[n-2].kind == dotID {
+				toks[n-2].text += text
+				toks = toks[:n-1]
+				continue
+			}
+			toks = append(toks, dotToken{kind: dotID, text: text, line: startLine})
+		case r == '+':
+			toks = append(toks, dotToken{kind: dotPunct, text: "+", line: line})
+			i++
+		case r == '<':
+			start, startLine, depth := i+1, line, 0
+			for ; i < len(rs); i++ {
+				if rs[i] == '<' {
+					depth++
+				} else if rs[i] == '>' {
+					depth--
+					if depth == 0 {
+						break
+					}
+				} else if rs[i] == '\n' {
+					line++
+				}
+			}
+			if i >= len(rs) {
+				return nil, fmt.Errorf("line %d: unterminated HTML string", startLine)
+			}
+			toks = append(toks, dotToken{kind: dotID, text: string(rs[start:i]), line: startLine})
+			i++
+		case r == '_' || r == '-' || r == '.' || unicode.IsLetter(r) || unicode.IsDigit(r) || r >= 0x80:
+			start := i
+			for i < len(rs) && (rs[i] == '_' || rs[i] == '.' || unicode.IsLetter(rs[i]) || unicode.IsDigit(rs[i]) || rs[i] >= 0x80 || (i == start && rs[i] == '-')) {
+				i++
+			}
+			toks = append(toks, dotToken{kind: dotID, text: string(rs[start:i]), line: line})
+		default:
+			return nil, fmt.Errorf("line %d: unexpected character %q", line, r)
+		}
+	}
+	return append(toks, dotToken{kind: dotEOF, line: line}), nil
+}
+
+// unquoteDOT decodes a quoted DOT string. Strings written by buildDOT use
+// Go quoting; anything else only has its escaped quotes decoded, leaving
+// Graphviz escapes such as \l alone.
+func unquoteDOT(s string) string {
+	if u, err := strconv.Unquote(s); err == nil {
+		return u
+	}
+	s = s[1 : len(s)-1]
+	s = strings.ReplaceAll(s, "\\\n", "")
+	return strings.ReplaceAll(s, `\"`, `"`)
+}
+
+type dotParser struct {
+	toks []dotToken
+	pos  int
+	g    *dotGraph
+}
+
+type dotScope struct {
+	nodeAttrs, edgeAttrs map[string]string
+	graphAttrs           map[string]string
+	cluster              string
+	mentioned            []string
+}
+
+func (p *dotParser) peek() dotToken {
+	return p.toks[p.pos]
+}
+
+func (p *dotParser) next() dotToken {
+	t := p.toks[p.pos]
+	if t.kind != dotEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *dotParser) isPunct(text string) bool {
+	t := p.peek()
+	return t.kind == dotPunct && t.text == text
+}
+
+func (p *dotParser) isKeyword(kw string) bool {
+	t := p.peek()
+	return t.kind == dotID && strings.EqualFold(t.text, kw)
+}
+
+func (p *dotParser) expect(text string) error {
+	if t := p.next(); t.kind != dotPunct || t.text != text {
+		return p.errorf(t, "expected %q", text)
+	}
+	return nil
+}
+
+func (p *dotParser) errorf(t dotToken, format string, args ...any) error {
+	found := t.text
+	if t.kind == dotEOF {
+		found = "end of input"
+	}
+	return fmt.Errorf("line %d: %v, found %q", t.line, fmt.Sprintf(format, args...), found)
+}
+
+func (p *dotParser) parseGraph() (*dotGraph, error) {
+	p.g = &dotGraph{Attrs: make(map[string]string), nodes: make(map[string]*dotNode)}
+	if p.isKeyword("strict") {
+		p.next()
+	}
+	switch {
+	case p.isKeyword("digraph"):
+		p.g.Directed = true
+	case p.isKeyword("graph"):
+	default:
+		return nil, p.errorf(p.peek(), "expected graph or digraph")
+	}
+	p.next()
+	if t := p.peek(); t.kind == dotID {
+		p.g.ID = t.text
+		p.next()
+	}
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	sc := &dotScope{
+		nodeAttrs:  make(map[string]string),
+		edgeAttrs:  make(map[string]string),
+		graphAttrs: p.g.Attrs,
+	}
+	if err := p.parseStmtList(sc); err != nil {
+		return nil, err
+	}
+	if err := p.expect("}"); err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != dotEOF {
+		return nil, p.errorf(t, "expected end of input")
+	}
+	return p.g, nil
+}
+
+func (p *dotParser) parseStmtList(sc *dotScope) error {
+	for !p.isPunct("}") && p.peek().kind != dotEOF {
+		if err := p.parseStmt(sc); err != nil {
+			return err
+		}
+		if p.isPunct(";") {
+			p.next()
+		}
+	}
+	return nil
+}
+
+func (p *dotParser) parseStmt(sc *dotScope) error {
+	t := p.peek()
+	if t.kind == dotID && p.toks[p.pos+1].kind == dotPunct && p.toks[p.pos+1].text == "[" {
+		var target map[string]string
+		switch strings.ToLower(t.text) {
+		case "graph":
+			target = sc.graphAttrs
+		case "node":
+			target = sc.nodeAttrs
+		case "edge":
+			target = sc.edgeAttrs
+		}
+		if target != nil {
+			p.next()
+			attrs, err := p.parseAttrLists()
+			if err != nil {
+				return err
+			}
+			maps.Copy(target, attrs)
+			return nil
+		}
+	}
+	if t.kind == dotID && p.toks[p.pos+1].kind == dotPunct && p.toks[p.pos+1].text == "=" {
+		p.next()
+		p.next()
+		v := p.next()
+		if v.kind != dotID {
+			return p.errorf(v, "expected attribute value")
+		}
+		sc.graphAttrs[t.text] = v.text
+		return nil
+	}
+	isSubgraph := p.isPunct("{") || p.isKeyword("subgraph")
+	group, err := p.parseEndpoint(sc)
+	if err != nil {
+		return err
+	}
+	if !p.isPunct("->") && !p.isPunct("--") {
+		if isSubgraph {
+			return nil
+		}
+		attrs, err := p.parseAttrLists()
+		if err != nil {
+			return err
+		}
+		maps.Copy(p.g.nodes[group[0]].Attrs, attrs)
+		return nil
+	}
+	groups := [][]string{group}
+	for p.isPunct("->") || p.isPunct("--") {
+		p.next()
+		group, err := p.parseEndpoint(sc)
+		if err != nil {
+			return err
+		}
+		groups = append(groups, group)
+	}
+	attrs, err := p.parseAttrLists()
+	if err != nil {
+		return err
+	}
+	for i := 1; i < len(groups); i++ {
+		for _, from := range groups[i-1] {
+			for _, to := range groups[i] {
+				edgeAttrs := maps.Clone(sc.edgeAttrs)
+				maps.Copy(edgeAttrs, attrs)
+				p.g.Edges = append(p.g.Edges, &dotEdge{From: from, To: to, Attrs: edgeAttrs})
+			}
+		}
+	}
+	return nil
+}
+
+// parseEndpoint reads a node ID or a subgraph, returning the nodes it
+// stands for.
+func (p *dotParser) parseEndpoint(sc *dotScope) ([]string, error) {
+	if p.isPunct("{") || p.isKeyword("subgraph") {
+		return p.parseSubgraph(sc)
+	}
+	t := p.next()
+	if t.kind != dotID {
+		return nil, p.errorf(t, "expected node, edge or attribute statement")
+	}
+	// Ports (node:port:compass) do not matter here.
+	for p.isPunct(":") {
+		p.next()
+		if port := p.next(); port.kind != dotID {
+			return nil, p.errorf(port, "expected port")
+		}
+	}
+	p.g.node(t.text, sc)
+	sc.mentioned = append(sc.mentioned, t.text)
+	return []string{t.text}, nil
+}
+
+func (p *dotParser) parseSubgraph(parent *dotScope) ([]string, error) {
+	sc := &dotScope{
+		nodeAttrs:  maps.Clone(parent.nodeAttrs),
+		edgeAttrs:  maps.Clone(parent.edgeAttrs),
+		graphAttrs: make(map[string]string),
+		cluster:    parent.cluster,
+	}
+	if p.isKeyword("subgraph") {
+		p.next()
+		if p.peek().kind == dotID {
+			if id := p.next().text; strings.HasPrefix(id, "cluster") {
+				sc.cluster = id
+				p.g.Clusters = append(p.g.Clusters, &dotCluster{ID: id, Attrs: sc.graphAttrs})
+			}
+		}
+	}
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	if err := p.parseStmtList(sc); err != nil {
+		return nil, err
+	}
+	if err := p.expect("}"); err != nil {
+		return nil, err
+	}
+	parent.mentioned = append(parent.mentioned, sc.mentioned...)
+	return sc.mentioned, nil
+}
+
+func (p *dotParser) parseAttrLists() (map[string]string, error) {
+	attrs := make(map[string]string)
+	for p.isPunct("[") {
+		p.next()
+		for !p.isPunct("]") {
+			k := p.next()
+			if k.kind != dotID {
+				return nil, p.errorf(k, "expected attribute name")
+			}
+			if err := p.expect("="); err != nil {
+				return nil, err
+			}
+			v := p.next()
+			if v.kind != dotID {
+				return nil, p.errorf(v, "expected attribute value")
+			}
+			attrs[k.text] = v.text
+			if p.isPunct(",") || p.isPunct(";") {
+				p.next()
+			}
+		}
+		p.next()
+	}
+	return attrs, nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseDOTRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		deps   []dep
+		states map[string]bubbleState
+	}{
+		{
+			name:   "chain",
+			deps:   []dep{{Left: "design", Right: "build"}, {Left: "build", Right: "ship"}},
+			states: map[string]bubbleState{"design": done, "build": started},
+		},
+		{
+			name:   "fan out",
+			deps:   []dep{{Left: "a", Right: "b"}, {Left: "a", Right: "c"}, {Left: "b", Right: "d"}, {Left: "c", Right: "d"}},
+			states: map[string]bubbleState{"a": done, "c": aborted},
+		},
+		{
+			name:   "names to escape",
+			deps:   []dep{{Left: `say "hi"`, Right: "back\\slash"}, {Left: "back\\slash", Right: "multi\nline"}},
+			states: map[string]bubbleState{`say "hi"`: done},
+		},
+		{
+			name:   "unicode",
+			deps:   []dep{{Left: "café", Right: "naïve → done"}, {Left: "naïve → done", Right: "日本"}},
+			states: map[string]bubbleState{"日本": started},
+		},
+		{
+			name: "keywords as names",
+			deps: []dep{{Left: "node", Right: "edge"}, {Left: "edge", Right: "graph"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := buildDOT(project{ID: 1, Name: tt.name}, tt.deps, tt.states, dotOptions{})
+			g, err := parseDOT(src)
+			if err != nil {
+				t.Fatalf("parseDOT: %v\n%v", err, src)
+			}
+			if !g.Directed {
+				t.Error("graph is not directed")
+			}
+			var deps []dep
+			for _, e := range g.Edges {
+				deps = append(deps, dep{Left: e.From, Right: e.To})
+			}
+			if !slices.Equal(deps, tt.deps) {
+				t.Errorf("edges = %q, want %q", deps, tt.deps)
+			}
+			states := make(map[string]bubbleState)
+			for _, n := range g.Nodes {
+				if state := stateFromAttrs(n.Attrs); state != initial {
+					states[n.ID] = state
+				}
+			}
+			if !maps.Equal(states, tt.states) {
+				t.Errorf("states = %v, want %v", states, tt.states)
+			}
+		})
+	}
+}
+
+func TestParseDOT(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		edges    []string
+		clusters map[string]string
+	}{
+		{
+			name:  "chained edges with attributes",
+			src:   `digraph { a -> b -> c [color=red] }`,
+			edges: []string{"a->b", "b->c"},
+		},
+		{
+			name:  "subgraphs as endpoints",
+			src:   `digraph { {a b} -> {c d} }`,
+			edges: []string{"a->c", "a->d", "b->c", "b->d"},
+		},
+		{
+			name:  "comments, ports and concatenation",
+			src:   "// top\ndigraph G {\n# hash\n\"a\" + \"b\":p:n -> c /* block\n comment */ ; }",
+			edges: []string{"ab->c"},
+		},
+		{
+			name:     "clusters",
+			src:      `digraph { subgraph cluster_x { label="x"; a } a -> b }`,
+			edges:    []string{"a->b"},
+			clusters: map[string]string{"a": "cluster_x", "b": ""},
+		},
+		{
+			name:  "undirected",
+			src:   `strict graph { a -- b }`,
+			edges: []string{"a->b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := parseDOT(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var edges []string
+			for _, e := range g.Edges {
+				edges = append(edges, e.From+"->"+e.To)
+			}
+			if !slices.Equal(edges, tt.edges) {
+				t.Errorf("edges = %q, want %q", edges, tt.edges)
+			}
+			for _, n := range g.Nodes {
+				if want, ok := tt.clusters[n.ID]; ok && n.Cluster != want {
+					t.Errorf("cluster of %v = %q, want %q", n.ID, n.Cluster, want)
+				}
+			}
+		})
+	}
+}
+
+func TestParseDOTErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"empty", "", "expected graph or digraph"},
+		{"not a graph", "a -> b", "expected graph or digraph"},
+		{"missing brace", "digraph a -> b }", `expected "{"`},
+		{"unclosed graph", "digraph { a -> b", `line 1: expected "}", found "end of input"`},
+		{"trailing input", "digraph { } x", "expected end of input"},
+		{"unterminated string", "digraph {\n\"a -> b }", "line 2: unterminated string"},
+		{"unterminated comment", "digraph { /* a -> b }", "unterminated comment"},
+		{"unterminated HTML", "digraph { a [label=<<b> }", "unterminated HTML string"},
+		{"stray character", "digraph { a -> b @ }", `unexpected character '@'`},
+		{"dangling edge", "digraph { a -> }", "expected node, edge or attribute statement"},
+		{"attribute without value", "digraph { a [color=] }", "expected attribute value"},
+		{"unclosed attributes", "digraph { a [color=red", "expected attribute name"},
+		{"missing port", "digraph { a: -> b }", "expected port"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := parseDOT(tt.src)
+			if err == nil {
+				t.Fatalf("parseDOT(%q) = %+v, want an error", tt.src, g)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("parseDOT(%q) = %v, want %q", tt.src, err, tt.want)
+			}
+		})
+	}
+}
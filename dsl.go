@@ -0,0 +1,291 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// dslScript is a parsed block of the edge DSL. Each line is a chain of
+// bubbles joined by arrows, where a step can be a single bubble or a group
+// of bubbles in braces, and bubbles can carry a state in brackets:
+//
+//	design -> build -> {test docs} -> release
+//	design [done]
+//	"user research" [started] -> design
+//
+// Blank lines and anything after a # are ignored.
+type dslScript struct {
+	Pairs  []dslPair
+	States []dslState
+}
+
+type dslPair struct {
+	dep
+	Line int
+}
+
+type dslState struct {
+	bubble
+	Line int
+}
+
+// dslError is a mistake in a line of a script.
+type dslError struct {
+	Line int
+	Msg  string
+}
+
+func (e *dslError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// parseDSL reads a script, reporting every line it cannot understand.
+func parseDSL(src string) (dslScript, error) {
+	var (
+		script dslScript
+		errs   []error
+	)
+	for i, line := range strings.Split(src, "\n") {
+		if err := parseDSLLine(&script, i+1, line); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return script, errors.Join(errs...)
+}
+
+func parseDSLLine(script *dslScript, lineNo int, line string) error {
+	toks, err := lexDSL(line)
+	if err != nil {
+		return &dslError{Line: lineNo, Msg: err.Error()}
+	}
+	if len(toks) == 0 {
+		return nil
+	}
+	p := &dslParser{toks: toks}
+	var (
+		pairs  []dslPair
+		states []dslState
+		prev   []string
+	)
+	for {
+		group, groupStates, err := p.group()
+		if err != nil {
+			return &dslError{Line: lineNo, Msg: err.Error()}
+		}
+		for _, left := range prev {
+			for _, right := range group {
+				pairs = append(pairs, dslPair{dep: dep{Left: left, Right: right}, Line: lineNo})
+			}
+		}
+		for _, b := range groupStates {
+			states = append(states, dslState{bubble: b, Line: lineNo})
+		}
+		prev = group
+		if p.done() {
+			break
+		}
+		if t := p.next(); t != "->" {
+			return &dslError{Line: lineNo, Msg: fmt.Sprintf("expected -> or end of line, found %q", t)}
+		}
+		if p.done() {
+			return &dslError{Line: lineNo, Msg: "missing bubble after ->"}
+		}
+	}
+	script.Pairs = append(script.Pairs, pairs...)
+	script.States = append(script.States, states...)
+	return nil
+}
+
+type dslParser struct {
+	toks []dslToken
+	pos  int
+}
+
+type dslToken struct {
+	text   string
+	quoted bool
+}
+
+func (p *dslParser) done() bool {
+	return p.pos >= len(p.toks)
+}
+
+func (p *dslParser) peek() dslToken {
+	if p.done() {
+		return dslToken{}
+	}
+	return p.toks[p.pos]
+}
+
+func (p *dslParser) next() string {
+	t := p.peek()
+	p.pos++
+	return t.text
+}
+
+func (p *dslParser) isPunct(s string) bool {
+	t := p.peek()
+	return !t.quoted && t.text == s && !p.done()
+}
+
+// group reads a bubble, or a braced list of bubbles, with their states.
+func (p *dslParser) group() ([]string, []bubble, error) {
+	if !p.isPunct("{") {
+		name, state, err := p.item()
+		if err != nil {
+			return nil, nil, err
+		}
+		var states []bubble
+		if state != "" {
+			states = append(states, bubble{Bubble: name, State: state})
+		}
+		return []string{name}, states, nil
+	}
+	p.next()
+	var (
+		names  []string
+		states []bubble
+	)
+	for !p.isPunct("}") {
+		if p.done() {
+			return nil, nil, errors.New("missing }")
+		}
+		name, state, err := p.item()
+		if err != nil {
+			return nil, nil, err
+		}
+		names = append(names, name)
+		if state != "" {
+			states = append(states, bubble{Bubble: name, State: state})
+		}
+	}
+	p.next()
+	if len(names) == 0 {
+		return nil, nil, errors.New("empty group {}")
+	}
+	return names, states, nil
+}
+
+// item reads a bubble name and its optional [state].
+func (p *dslParser) item() (string, bubbleState, error) {
+	t := p.peek()
+	if p.done() {
+		return "", "", errors.New("missing bubble")
+	}
+	if !t.quoted && isDSLPunct(t.text) {
+		return "", "", fmt.Errorf("expected a bubble, found %q", t.text)
+	}
+	p.next()
+	if !p.isPunct("[") {
+		return t.text, "", nil
+	}
+	p.next()
+	s := p.peek()
+	if p.done() || (!s.quoted && isDSLPunct(s.text)) {
+		return "", "", fmt.Errorf("missing state for %q", t.text)
+	}
+	p.next()
+	state, err := parseBubbleState(s.text)
+	if err != nil {
+		return "", "", err
+	}
+	if !p.isPunct("]") {
+		return "", "", fmt.Errorf("missing ] after state of %q", t.text)
+	}
+	p.next()
+	return t.text, state, nil
+}
+
+func isDSLPunct(s string) bool {
+	switch s {
+	case "->", "{", "}", "[", "]":
+		return true
+	}
+	return false
+}
+
+// lexDSL splits a line into arrows, brackets, braces and names. Names are
+// bare words or double quoted strings.
+func lexDSL(line string) ([]dslToken, error) {
+	var toks []dslToken
+	for i := 0; i < len(line); {
+		c, size := utf8.DecodeRuneInString(line[i:])
+		switch {
+		case c == '#':
+			return toks, nil
+		case unicode.IsSpace(c):
+			i += size
+		case strings.HasPrefix(line[i:], "->"):
+			toks = append(toks, dslToken{text: "->"})
+			i += 2
+		case strings.ContainsRune("{}[]", c):
+			toks = append(toks, dslToken{text: string(c)})
+			i++
+		case c == '"':
+			end := i + 1
+			for end < len(line) && line[end] != '"' {
+				if line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(line) {
+				return nil, errors.New("unterminated string")
+			}
+			s, err := strconv.Unquote(line[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string %s", line[i:end+1])
+			}
+			if strings.TrimSpace(s) == "" {
+				return nil, errors.New("empty bubble name")
+			}
+			toks = append(toks, dslToken{text: s, quoted: true})
+			i = end + 1
+		default:
+			end := i
+			for end < len(line) {
+				r, size := utf8.DecodeRuneInString(line[end:])
+				if unicode.IsSpace(r) || strings.ContainsRune("{}[]#\"", r) || strings.HasPrefix(line[end:], "->") {
+					break
+				}
+				end += size
+			}
+			toks = append(toks, dslToken{text: line[i:end]})
+			i = end
+		}
+	}
+	return toks, nil
+}
+
+// applyDSL inserts the script's pairs, as the pair form does, and then sets
+// the states it names. Errors are reported against the offending line.
+func applyDSL(j *journal, pID int64, script dslScript) error {
+	for _, pair := range script.Pairs {
+		if _, err := insertPair(j, pID, pair.Left, pair.Right); err != nil {
+			return wrapDSLError(pair.Line, err)
+		}
+	}
+	for _, state := range script.States {
+		if err := changeBubbleState(j, pID, state.Bubble, state.State); err != nil {
+			return wrapDSLError(state.Line, err)
+		}
+	}
+	return nil
+}
+
+// wrapDSLError turns the user's mistakes into line errors, leaving other
+// failures as they are.
+func wrapDSLError(line int, err error) error {
+	var (
+		cycleErr   *cycleError
+		blockedErr *blockedError
+	)
+	if errors.As(err, &cycleErr) || errors.As(err, &blockedErr) {
+		return &dslError{Line: line, Msg: err.Error()}
+	}
+	return err
+}
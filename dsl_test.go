@@ -0,0 +1,166 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLexDSL(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"", nil},
+		{"   # only a comment", nil},
+		{"a->b", []string{"a", "->", "b"}},
+		{"a -> {b c} -> d # done soon", []string{"a", "->", "{", "b", "c", "}", "->", "d"}},
+		{`"user research" [started]`, []string{"user research", "[", "started", "]"}},
+		{`"say \"hi\"" -> "a # b"`, []string{`say "hi"`, "->", "a # b"}},
+		{"well-known -> x", []string{"well-known", "->", "x"}},
+		// à ends in the byte 0xa0 and … in 0x85, which are spaces when read
+		// as Latin-1.
+		{"voilà -> café…", []string{"voilà", "->", "café…"}},
+		{"日本\u00a0→\u3000x", []string{"日本", "→", "x"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			toks, err := lexDSL(tt.line)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, tok := range toks {
+				got = append(got, tok.text)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("lexDSL(%q) = %q, want %q", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLexDSLErrors(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{`"open`, "unterminated string"},
+		{`a -> "b\"`, "unterminated string"},
+		{`"bad \q escape"`, "invalid string"},
+		{`"  " -> a`, "empty bubble name"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			if _, err := lexDSL(tt.line); err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+				t.Errorf("lexDSL(%q) = %v, want %q", tt.line, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseDSL(t *testing.T) {
+	src := "design -> build -> {test docs} -> release\n\n  design [done] # finished\n\"user research\" [started] -> design"
+	script, err := parseDSL(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantPairs := []dslPair{
+		{dep{Left: "design", Right: "build"}, 1},
+		{dep{Left: "build", Right: "test"}, 1},
+		{dep{Left: "build", Right: "docs"}, 1},
+		{dep{Left: "test", Right: "release"}, 1},
+		{dep{Left: "docs", Right: "release"}, 1},
+		{dep{Left: "user research", Right: "design"}, 4},
+	}
+	if !slices.Equal(script.Pairs, wantPairs) {
+		t.Errorf("pairs = %v, want %v", script.Pairs, wantPairs)
+	}
+	wantStates := []dslState{
+		{bubble{Bubble: "design", State: done}, 3},
+		{bubble{Bubble: "user research", State: started}, 4},
+	}
+	if !slices.Equal(script.States, wantStates) {
+		t.Errorf("states = %v, want %v", script.States, wantStates)
+	}
+}
+
+func TestParseDSLErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"a ->", "line 1: missing bubble after ->"},
+		{"-> a", `line 1: expected a bubble, found "->"`},
+		{"a b", `line 1: expected -> or end of line, found "b"`},
+		{"a -> {b c", "line 1: missing }"},
+		{"a -> {}", "line 1: empty group {}"},
+		{"a [", `line 1: missing state for "a"`},
+		{"a [done", `line 1: missing ] after state of "a"`},
+		{"a [finished]", "line 1: "},
+		{"ok -> fine\na ->\nb -> ]", "line 2: missing bubble after ->\nline 3: expected a bubble"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			_, err := parseDSL(tt.src)
+			if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+				t.Errorf("parseDSL(%q) = %v, want %q", tt.src, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyDSL(t *testing.T) {
+	tests := []struct {
+		name   string
+		strict bool
+		src    string
+		want   string
+	}{
+		{"pairs and states", false, "a -> b -> c\na [done]", ""},
+		{"cycle", false, "a -> b\n\nb -> a", "line 3: pair would create a cycle: b -> a -> b"},
+		{"self loop", false, "a -> a", "line 1: pair would create a cycle: a -> a"},
+		{"blocked in strict mode", true, "a -> b\nb [done]", "line 2: "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			pID := newTestProject(t, db)
+			script, err := parseDSL(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = withJournal(db, "test", func(j *journal) error {
+				if tt.strict {
+					p, err := loadProject(j, pID)
+					if err != nil {
+						return err
+					}
+					p.Strict = true
+					if err := saveProject(j, p); err != nil {
+						return err
+					}
+				}
+				return applyDSL(j, pID, script)
+			})
+			if tt.want == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				deps, states, err := loadGraph(db, pID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(deps) != 2 || states["a"] != done {
+					t.Errorf("got pairs %v and states %v", deps, states)
+				}
+				return
+			}
+			var dslErr *dslError
+			if !errors.As(err, &dslErr) || !strings.HasPrefix(err.Error(), tt.want) {
+				t.Errorf("applyDSL = %v, want a line error starting with %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// historySize is how many events the project page shows.
+const historySize = 50
+
+type eventKind string
+
+const (
+	projectCreated  eventKind = "project created"
+	projectDeleted  eventKind = "project deleted"
+	projectRenamed  eventKind = "project renamed"
+	settingsChanged eventKind = "settings changed"
+	pairAdded       eventKind = "pair added"
+	pairRemoved     eventKind = "pair removed"
+	bubbleRenamed   eventKind = "bubble renamed"
+	stateChanged    eventKind = "state changed"
+	durationChanged eventKind = "duration changed"
+	infoChanged     eventKind = "details changed"
+)
+
+// event is an entry of the append-only history of a project. Pair events
+// carry Left and Right; bubble events carry Bubble; changes of value carry
+// the Old and New values.
+type event struct {
+	ID      int64     `json:"id"`
+	Project int64     `json:"project"`
+	At      time.Time `json:"at"`
+	Actor   string    `json:"actor"`
+	Kind    eventKind `json:"kind"`
+	Bubble  string    `json:"bubble,omitempty"`
+	Left    string    `json:"left,omitempty"`
+	Right   string    `json:"right,omitempty"`
+	Old     string    `json:"old,omitempty"`
+	New     string    `json:"new,omitempty"`
+
+	// Via tells whether the change was made by an undo or a redo.
+	Via string `json:"via,omitempty"`
+}
+
+func (e event) String() string {
+	if e.Via != "" {
+		via := e
+		via.Via = ""
+		return fmt.Sprintf("%v (%v)", via, e.Via)
+	}
+	switch e.Kind {
+	case pairAdded, pairRemoved:
+		return fmt.Sprintf("%v: %v -> %v", e.Kind, e.Left, e.Right)
+	case bubbleRenamed:
+		return fmt.Sprintf("%v renamed to %v", e.Old, e.New)
+	case stateChanged:
+		return fmt.Sprintf("%v: %v -> %v", e.Bubble, e.Old, e.New)
+	case durationChanged:
+		return fmt.Sprintf("%v: duration %v -> %v", e.Bubble, e.Old, e.New)
+	case infoChanged:
+		return fmt.Sprintf("%v: %v", e.Bubble, e.Kind)
+	case projectCreated:
+		return fmt.Sprintf("%v: %v", e.Kind, e.New)
+	case projectDeleted:
+		return fmt.Sprintf("%v: %v", e.Kind, e.Old)
+	default:
+		return fmt.Sprintf("%v: %v -> %v", e.Kind, e.Old, e.New)
+	}
+}
+
+// journal is a transaction that records every mutation it carries in the
+// events table, on behalf of actor. It also keeps the state of each project
+// as it was before the transaction changed it, so the change can be undone.
+type journal struct {
+	*sql.Tx
+	actor string
+
+	// via is set to undoStack or redoStack while the journal replays a
+	// previous state of the project.
+	via string
+
+	before map[int64]projectState
+	labels map[int64]string
+
+	// ready holds, for the projects with webhooks on bubbleReady, which
+	// bubbles were ready before the journal changed them. queued is set
+	// when the journal queued webhook deliveries.
+	ready  map[int64]map[string]bool
+	queued bool
+}
+
+// checkpoint captures the state of the project before the first mutation
+// the journal makes to it. Mutations call it before touching the database.
+func (j *journal) checkpoint(pID int64) error {
+	if err := j.noteReady(pID); err != nil {
+		return err
+	}
+	if j.via != "" {
+		return nil
+	}
+	if _, ok := j.before[pID]; ok {
+		return nil
+	}
+	state, err := captureState(j, pID)
+	if err != nil {
+		return err
+	}
+	j.before[pID] = state
+	return nil
+}
+
+func (j *journal) record(ev event) error {
+	ev.At = time.Now().UTC()
+	ev.Actor = j.actor
+	ev.Via = j.via
+	result, err := j.Exec(`
+		insert into events (project, at, actor, kind, bubble, left, right, old, new, via)
+			values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, ev.Project, ev.At, ev.Actor, ev.Kind, ev.Bubble, ev.Left, ev.Right, ev.Old, ev.New, ev.Via)
+	if err != nil {
+		return err
+	}
+	if ev.ID, err = result.LastInsertId(); err != nil {
+		return err
+	}
+	if _, ok := j.labels[ev.Project]; !ok {
+		j.labels[ev.Project] = ev.String()
+	}
+	return queueWebhooks(j, ev)
+}
+
+// withJournal runs fn in a transaction, committing it only if fn succeeds.
+// Pages showing the projects it changed, and their webhooks, are told once
+// it commits.
+func withJournal(db *sql.DB, actor string, fn func(j *journal) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	j := &journal{
+		Tx:     tx,
+		actor:  actor,
+		before: make(map[int64]projectState),
+		labels: make(map[int64]string),
+		ready:  make(map[int64]map[string]bool),
+	}
+	if err := fn(j); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if err := j.queueReady(); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if err := j.saveCheckpoints(); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for pID := range j.labels {
+		projectChanges.publish(pID)
+	}
+	if j.queued {
+		wakeWebhooks()
+	}
+	return nil
+}
+
+// actorOf identifies who is making the request: the user signed in or, for
+// requests made without an account, the address they come from.
+func actorOf(r *http.Request) string {
+	if acct, ok := accountOf(r); ok {
+		return acct.Name
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// loadEvents returns the latest events of the project, newest first.
+func loadEvents(q queryer, pID int64, limit int) ([]event, error) {
+	rows, err := q.Query(`
+		select id, project, at, actor, kind, bubble, left, right, old, new, via
+			from events where project = ? order by id desc limit ?
+	`, pID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := []event{}
+	for rows.Next() {
+		var ev event
+		if err := rows.Scan(&ev.ID, &ev.Project, &ev.At, &ev.Actor, &ev.Kind, &ev.Bubble, &ev.Left, &ev.Right, &ev.Old, &ev.New, &ev.Via); err != nil {
+			return nil, err
+		}
+		events = append(events, ev)
+	}
+	return events, rows.Err()
+}
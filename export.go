@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// exportFormat is a way of downloading a project.
+type exportFormat struct {
+	Name        string // value of the format parameter
+	Label       string // entry of the download menu
+	ContentType string
+	Filename    string
+
+	// rendered formats are drawn by the renderer; the others are built
+	// from the project directly.
+	rendered bool
+}
+
+var exportFormats = []exportFormat{
+	{Name: "png", Label: "PNG image", ContentType: "image/png", Filename: "graph.png", rendered: true},
+	{Name: "svg", Label: "SVG image", ContentType: "image/svg+xml", Filename: "graph.svg", rendered: true},
+	{Name: "pdf", Label: "PDF document", ContentType: "application/pdf", Filename: "graph.pdf", rendered: true},
+	{Name: "dot", Label: "Graphviz source", ContentType: "text/vnd.graphviz; charset=utf-8", Filename: "graph.dot"},
+	{Name: "mermaid", Label: "Mermaid flowchart", ContentType: "text/plain; charset=utf-8", Filename: "graph.mmd"},
+	{Name: "gantt", Label: "Gantt chart (SVG)", ContentType: "image/svg+xml", Filename: "gantt.svg"},
+	{Name: "json", Label: "JSON document", ContentType: "application/json", Filename: "graph.json"},
+	{Name: "pairs-csv", Label: "pairs (CSV)", ContentType: "text/csv; charset=utf-8", Filename: "pairs.csv"},
+	{Name: "bubbles-csv", Label: "bubbles (CSV)", ContentType: "text/csv; charset=utf-8", Filename: "bubbles.csv"},
+}
+
+func findExportFormat(name string) (exportFormat, bool) {
+	for _, f := range exportFormats {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return exportFormat{}, false
+}
+
+// exportProject produces the project in the given format; src is the
+// Graphviz source of the project as shown on the page.
+func exportProject(ctx context.Context, rdr renderer, f exportFormat, state projectState, src string, vertical bool) ([]byte, error) {
+	deps, states := state.Pairs, state.States
+	switch {
+	case f.rendered:
+		return rdr.render(ctx, src, f.Name)
+	case f.Name == "dot":
+		return []byte(src), nil
+	case f.Name == "mermaid":
+		return []byte(buildMermaid(deps, states, vertical)), nil
+	case f.Name == "gantt":
+		tasks, ok := schedule(deps, states, state.Durations)
+		if !ok {
+			return nil, errors.New("cannot schedule a graph with loops")
+		}
+		return renderGantt(tasks, projectStart(state.Project, time.Now())), nil
+	case f.Name == "json":
+		out := &bytes.Buffer{}
+		enc := json.NewEncoder(out)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "\t")
+		err := enc.Encode(newProjectDocument(state))
+		return out.Bytes(), err
+	case f.Name == "pairs-csv":
+		return writePairsCSV(deps)
+	case f.Name == "bubbles-csv":
+		return writeBubblesCSV(knownBubbles(deps, states))
+	}
+	return nil, fmt.Errorf("%w: %s", errUnsupportedFormat, f.Name)
+}
+
+// buildMermaid renders the project graph as a Mermaid flowchart, with the
+// bubbles filled with the color of their state.
+func buildMermaid(deps []dep, states map[string]bubbleState, vertical bool) string {
+	out := &bytes.Buffer{}
+	if vertical {
+		fmt.Fprintln(out, "flowchart TD")
+	} else {
+		fmt.Fprintln(out, "flowchart LR")
+	}
+	fmt.Fprintln(out, "	classDef started fill:yellow")
+	fmt.Fprintln(out, "	classDef done fill:lightgreen")
+	fmt.Fprintln(out, "	classDef aborted fill:red")
+	// Bubble names may contain anything, so nodes get synthetic IDs and
+	// the names become quoted labels.
+	ids := make(map[string]string)
+	for i, bubble := range knownBubbles(deps, states) {
+		id := fmt.Sprintf("b%d", i)
+		ids[bubble.Bubble] = id
+		fmt.Fprintf(out, "	%v[\"%v\"]\n", id, mermaidEscaper.Replace(bubble.Bubble))
+		if bubble.State != initial {
+			fmt.Fprintf(out, "	class %v %v\n", id, bubble.State)
+		}
+	}
+	for _, dep := range deps {
+		fmt.Fprintf(out, "	%v --> %v\n", ids[dep.Left], ids[dep.Right])
+	}
+	return out.String()
+}
+
+var mermaidEscaper = strings.NewReplacer(`"`, "#quot;", "\n", " ")
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+const startDateLayout = "2006-01-02"
+
+var errInvalidStart = errors.New("start date must look like 2006-01-02")
+
+// parseStartDate checks a project start date. An empty string means today.
+func parseStartDate(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", nil
+	}
+	if _, err := time.Parse(startDateLayout, s); err != nil {
+		return "", errInvalidStart
+	}
+	return s, nil
+}
+
+// projectStart returns the day the project begins.
+func projectStart(p project, now time.Time) time.Time {
+	if t, err := time.Parse(startDateLayout, p.Start); err == nil {
+		return t
+	}
+	y, m, d := now.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// ganttTask is a bubble placed on the timeline, in days since the start of
+// the project.
+type ganttTask struct {
+	Bubble        string
+	State         bubbleState
+	Start, Finish float64
+}
+
+// schedule starts every bubble as soon as all its predecessors finish, and
+// lists them by start, then by name. Bubbles without an estimate take no
+// time. Graphs with loops cannot be scheduled.
+func schedule(deps []dep, states map[string]bubbleState, durations map[string]float64) ([]ganttTask, bool) {
+	order, ok := topoOrder(deps)
+	if !ok {
+		return nil, false
+	}
+	preds := make(map[string][]string)
+	for _, dep := range deps {
+		preds[dep.Right] = append(preds[dep.Right], dep.Left)
+	}
+	finish := make(map[string]float64)
+	tasks := make([]ganttTask, 0, len(order))
+	for _, n := range order {
+		start := 0.0
+		for _, p := range preds[n] {
+			start = max(start, finish[p])
+		}
+		finish[n] = start + durations[n]
+		state := states[n]
+		if state == "" {
+			state = initial
+		}
+		tasks = append(tasks, ganttTask{Bubble: n, State: state, Start: start, Finish: finish[n]})
+	}
+	sort.SliceStable(tasks, func(a, b int) bool {
+		if tasks[a].Start != tasks[b].Start {
+			return tasks[a].Start < tasks[b].Start
+		}
+		return tasks[a].Bubble < tasks[b].Bubble
+	})
+	return tasks, true
+}
+
+// Geometry of the Gantt chart, in points.
+const (
+	ganttRowHeight = 24.0
+	ganttBarHeight = 16.0
+	ganttAxis      = 28.0
+	ganttMaxWidth  = 960.0
+	ganttDayWidth  = 32.0
+	// ganttGridlines is about the most day lines a chart gets, however
+	// long the project runs.
+	ganttGridlines = 200
+)
+
+// ganttFill is the fill of a bar, matching the colors of the graph.
+func ganttFill(state bubbleState) string {
+	switch state {
+	case started:
+		return "yellow"
+	case done:
+		return "lightgreen"
+	case aborted:
+		return "red"
+	default:
+		return "white"
+	}
+}
+
+// renderGantt draws the tasks as an SVG Gantt chart, one row per bubble
+// and one column per day from start. Bubbles that take no time are drawn
+// as diamonds.
+func renderGantt(tasks []ganttTask, start time.Time) []byte {
+	labelWidth := 0.0
+	span := 1.0
+	for _, t := range tasks {
+		labelWidth = max(labelWidth, textWidth(t.Bubble))
+		span = max(span, math.Ceil(t.Finish))
+	}
+	labelWidth += 2 * layoutMargin
+	dayWidth := min(ganttDayWidth, (ganttMaxWidth-labelWidth)/span)
+	dayWidth = max(dayWidth, 2)
+	// Label as many days as fit without overlapping, with a line for each
+	// label.
+	every := int(math.Ceil(48 / dayWidth))
+	every = max(every, int(math.Ceil(span/ganttGridlines)))
+	width := labelWidth + span*dayWidth + layoutMargin
+	height := ganttAxis + float64(len(tasks))*ganttRowHeight + layoutMargin
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, `<svg width="%vpt" height="%vpt" viewBox="0 0 %v %v" xmlns="http://www.w3.org/2000/svg">`+"\n",
+		num(width), num(height), num(width), num(height))
+	fmt.Fprintf(buf, `<polygon fill="white" stroke="none" points="0,0 %v,0 %v,%v 0,%v"/>`+"\n", num(width), num(width), num(height), num(height))
+	for day := 0; day <= int(span); day += every {
+		x := labelWidth + float64(day)*dayWidth
+		fmt.Fprintf(buf, `<line x1="%v" y1="%v" x2="%v" y2="%v" stroke="lightgrey" stroke-width="0.5"/>`+"\n",
+			num(x), num(ganttAxis-4), num(x), num(height-layoutMargin))
+		if day < int(span) {
+			fmt.Fprintf(buf, `<text x="%v" y="%v" font-family="Times,serif" font-size="%v">%v</text>`+"\n",
+				num(x+2), num(ganttAxis-8), num(layoutFontSize-2), start.AddDate(0, 0, day).Format("Jan 2"))
+		}
+	}
+	for i, t := range tasks {
+		y := ganttAxis + float64(i)*ganttRowHeight
+		mid := y + ganttRowHeight/2
+		fmt.Fprintf(buf, `<g class="task"><title>%v: %v, %v to %v</title>`+"\n",
+			html.EscapeString(t.Bubble), t.State, ganttDate(start, t.Start), ganttDate(start, t.Finish))
+		fmt.Fprintf(buf, `<text x="%v" y="%v" font-family="Times,serif" font-size="%v">%v</text>`+"\n",
+			num(layoutMargin), num(mid+layoutFontSize*0.3), num(layoutFontSize), html.EscapeString(t.Bubble))
+		x0 := labelWidth + t.Start*dayWidth
+		if t.Finish == t.Start {
+			r := ganttBarHeight / 2
+			fmt.Fprintf(buf, `<polygon fill="%v" stroke="black" points="%v,%v %v,%v %v,%v %v,%v"/>`+"\n",
+				ganttFill(t.State), num(x0), num(mid-r), num(x0+r), num(mid), num(x0), num(mid+r), num(x0-r), num(mid))
+		} else {
+			fmt.Fprintf(buf, `<rect fill="%v" stroke="black" x="%v" y="%v" width="%v" height="%v"/>`+"\n",
+				ganttFill(t.State), num(x0), num(mid-ganttBarHeight/2), num((t.Finish-t.Start)*dayWidth), num(ganttBarHeight))
+		}
+		fmt.Fprintln(buf, "</g>")
+	}
+	fmt.Fprintln(buf, "</svg>")
+	return buf.Bytes()
+}
+
+// ganttDate names the day that is the given number of days after start.
+// Whole days are added as dates, as a time.Duration only spans centuries.
+func ganttDate(start time.Time, days float64) string {
+	whole, frac := math.Modf(days)
+	return start.AddDate(0, 0, int(whole)).Add(time.Duration(frac * float64(24*time.Hour))).Format("Jan 2")
+}
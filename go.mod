@@ -6,5 +6,6 @@ toolchain go1.24.5
 
 require (
 	github.com/mattn/go-sqlite3 v1.14.28
-	golang.org/x/exp v0.0.0-20250717185816-542afb5b7346
+	github.com/yuin/goldmark v1.8.2
+	golang.org/x/crypto v0.41.0
 )
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+var errUndirectedGraph = errors.New("expected a digraph")
+
+// stateFromAttrs reads a node's state back from the fill buildDOT gives it.
+// Nodes that are not filled, or filled with another color, are initial.
+func stateFromAttrs(attrs map[string]string) bubbleState {
+	if !strings.Contains(attrs["style"], "filled") {
+		return initial
+	}
+	fill := attrOr(attrs, "fillcolor", attrs["color"])
+	for _, state := range bubbleStates {
+		if color := state.color(); color != "" && strings.HasSuffix(color, "fillcolor="+strings.ToLower(fill)) {
+			return state
+		}
+	}
+	return initial
+}
+
+// importDOT creates a project out of a Graphviz digraph and returns its ID.
+// Every edge becomes a pair and node colors become bubble states. Nodes
+// without edges are skipped, as bubbles exist only through their pairs.
+func importDOT(j *journal, name string, g *dotGraph) (int64, error) {
+	if !g.Directed {
+		return 0, errUndirectedGraph
+	}
+	pID, err := createProject(j, name)
+	if err != nil {
+		return 0, err
+	}
+	paired := make(map[string]bool)
+	for _, e := range g.Edges {
+		if _, err := insertPair(j, pID, e.From, e.To); err != nil {
+			return 0, err
+		}
+		paired[e.From], paired[e.To] = true, true
+	}
+	for _, n := range g.Nodes {
+		if !paired[n.ID] {
+			continue
+		}
+		if state := stateFromAttrs(n.Attrs); state != initial {
+			if err := writeBubbleState(j, pID, n.ID, initial, state); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return pID, nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/yuin/goldmark"
+)
+
+// bubbleInfo is what a bubble carries besides its state and estimate.
+type bubbleInfo struct {
+	// Description is Markdown.
+	Description string `json:"description,omitempty"`
+	Assignee    string `json:"assignee,omitempty"`
+	// Due is a day, as YYYY-MM-DD.
+	Due string `json:"due,omitempty"`
+	// URL links the bubble to an outside tracker or document.
+	URL string `json:"url,omitempty"`
+	// Tags name the teams, components or milestones of the bubble, sorted.
+	Tags []string `json:"tags,omitempty"`
+}
+
+var (
+	errInvalidDue = errors.New("due date must look like 2006-01-02")
+	errInvalidURL = errors.New("url must be an absolute http or https address")
+)
+
+// parseBubbleInfo tidies up and checks the fields of a bubble.
+func parseBubbleInfo(description, assignee, due, link string, tags []string) (bubbleInfo, error) {
+	info := bubbleInfo{
+		Description: strings.TrimSpace(description),
+		Assignee:    strings.TrimSpace(assignee),
+		Due:         strings.TrimSpace(due),
+		URL:         strings.TrimSpace(link),
+		Tags:        normalizeTags(tags),
+	}
+	if info.Due != "" {
+		if _, err := time.Parse("2006-01-02", info.Due); err != nil {
+			return bubbleInfo{}, errInvalidDue
+		}
+	}
+	if info.URL != "" {
+		u, err := url.Parse(info.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return bubbleInfo{}, errInvalidURL
+		}
+	}
+	return info, nil
+}
+
+func (i bubbleInfo) equal(o bubbleInfo) bool {
+	return i.Description == o.Description && i.Assignee == o.Assignee && i.Due == o.Due && i.URL == o.URL && slices.Equal(i.Tags, o.Tags)
+}
+
+// tooltip lists the bubble's fields, one per line, for the graph.
+func (i bubbleInfo) tooltip() []string {
+	var lines []string
+	if i.Assignee != "" {
+		lines = append(lines, "assignee: "+i.Assignee)
+	}
+	if i.Due != "" {
+		lines = append(lines, "due: "+i.Due)
+	}
+	if len(i.Tags) > 0 {
+		lines = append(lines, "tags: "+strings.Join(i.Tags, ", "))
+	}
+	if i.URL != "" {
+		lines = append(lines, i.URL)
+	}
+	if i.Description != "" {
+		lines = append(lines, i.Description)
+	}
+	return lines
+}
+
+// DescriptionHTML renders the Markdown description. Raw HTML in it is left
+// out. It is exported for the templates.
+func (i bubbleInfo) DescriptionHTML() template.HTML {
+	var buf bytes.Buffer
+	if err := goldmark.Convert([]byte(i.Description), &buf); err != nil {
+		return template.HTML(template.HTMLEscapeString(i.Description))
+	}
+	return template.HTML(buf.String())
+}
+
+// loadInfos returns the fields of every bubble of the project that has any.
+func loadInfos(q queryer, pID int64) (map[string]bubbleInfo, error) {
+	rows, err := q.Query(`
+		select bubble, description, assignee, due, url, tags from bubbles
+			where project = ? and (description != '' or assignee != '' or due != '' or url != '' or tags != '')
+	`, pID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	infos := make(map[string]bubbleInfo)
+	for rows.Next() {
+		var (
+			name string
+			info bubbleInfo
+			tags string
+		)
+		if err := rows.Scan(&name, &info.Description, &info.Assignee, &info.Due, &info.URL, &tags); err != nil {
+			return nil, err
+		}
+		info.Tags = parseTags(tags)
+		infos[name] = info
+	}
+	return infos, rows.Err()
+}
+
+func bubbleInfoOf(q queryer, pID int64, name string) (bubbleInfo, error) {
+	var (
+		info bubbleInfo
+		tags string
+	)
+	err := q.QueryRow("select description, assignee, due, url, tags from bubbles where project = ? and bubble = ?", pID, name).
+		Scan(&info.Description, &info.Assignee, &info.Due, &info.URL, &tags)
+	if errors.Is(err, sql.ErrNoRows) {
+		return bubbleInfo{}, nil
+	} else if err != nil {
+		return bubbleInfo{}, err
+	}
+	info.Tags = parseTags(tags)
+	return info, nil
+}
+
+// changeBubbleInfo replaces the fields of the bubble.
+func changeBubbleInfo(j *journal, pID int64, name string, info bubbleInfo) error {
+	old, err := bubbleInfoOf(j, pID, name)
+	if err != nil {
+		return err
+	}
+	if old.equal(info) {
+		return nil
+	}
+	return writeBubbleInfo(j, pID, name, old, info)
+}
+
+func writeBubbleInfo(j *journal, pID int64, name string, old, info bubbleInfo) error {
+	if err := j.checkpoint(pID); err != nil {
+		return err
+	}
+	_, err := j.Exec(`
+		insert into bubbles (project, bubble, state, description, assignee, due, url, tags) values (?, ?, ?, ?, ?, ?, ?, ?)
+			on conflict (project, bubble) do update set
+				description = excluded.description,
+				assignee = excluded.assignee,
+				due = excluded.due,
+				url = excluded.url,
+				tags = excluded.tags
+	`, pID, name, initial, info.Description, info.Assignee, info.Due, info.URL, strings.Join(info.Tags, ","))
+	if err != nil {
+		return err
+	}
+	oldJSON, err := json.Marshal(old)
+	if err != nil {
+		return err
+	}
+	newJSON, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	return j.record(event{Project: pID, Kind: infoChanged, Bubble: name, Old: string(oldJSON), New: string(newJSON)})
+}
+
+// mergeInfo fills the empty fields of into with the ones of from, and
+// joins their tags, as when a bubble is renamed onto another.
+func mergeInfo(into, from bubbleInfo) bubbleInfo {
+	if into.Description == "" {
+		into.Description = from.Description
+	} else if from.Description != "" && from.Description != into.Description {
+		into.Description = fmt.Sprintf("%v\n\n%v", into.Description, from.Description)
+	}
+	if into.Assignee == "" {
+		into.Assignee = from.Assignee
+	}
+	if into.Due == "" {
+		into.Due = from.Due
+	}
+	if into.URL == "" {
+		into.URL = from.URL
+	}
+	into.Tags = normalizeTags(append(slices.Clone(into.Tags), from.Tags...))
+	return into
+}
@@ -0,0 +1,482 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Spacing of the built-in layout, in points. They follow Graphviz defaults.
+const (
+	layoutMargin    = 8.0
+	layoutNodeSep   = 18.0
+	layoutRankSep   = 36.0
+	layoutFontSize  = 14.0
+	layoutMinWidth  = 54.0
+	layoutMinHeight = 36.0
+	layoutDummy     = 4.0
+)
+
+type point struct {
+	X, Y float64
+}
+
+type layoutNode struct {
+	ID    string
+	Attrs map[string]string
+	Label []string
+	Pos   point
+	W, H  float64
+
+	dummy bool
+	layer int
+	order float64
+}
+
+type layoutEdge struct {
+	From, To string
+	Attrs    map[string]string
+	Points   []point
+}
+
+// layoutCluster is the box drawn around the nodes of a cluster.
+type layoutCluster struct {
+	ID       string
+	Label    string
+	Min, Max point
+}
+
+// layout is a graph whose nodes and edges have been given coordinates.
+type layout struct {
+	Width, Height float64
+	Nodes         []*layoutNode
+	Edges         []*layoutEdge
+	Clusters      []*layoutCluster
+}
+
+// layoutGraph places the graph in layers, Sugiyama style: it breaks cycles,
+// assigns each node to the layer after its deepest predecessor, routes long
+// edges through dummy nodes, orders every layer to reduce crossings with
+// the barycenter heuristic and finally assigns coordinates. Layers run top
+// to bottom, or left to right when rankdir is LR.
+func layoutGraph(g *dotGraph) *layout {
+	horizontal := strings.EqualFold(g.Attrs["rankdir"], "LR") || strings.EqualFold(g.Attrs["rankdir"], "RL")
+	nodes := make(map[string]*layoutNode)
+	var order []*layoutNode
+	for _, n := range g.Nodes {
+		ln := &layoutNode{ID: n.ID, Attrs: n.Attrs, Label: nodeLabel(n)}
+		ln.W, ln.H = nodeSize(ln.Label, n.Attrs)
+		nodes[n.ID] = ln
+		order = append(order, ln)
+	}
+
+	// Break cycles by reversing the edges that point back to a node still
+	// being visited by a depth-first search.
+	type arc struct {
+		from, to string
+		edge     *dotEdge
+		reversed bool
+	}
+	out := make(map[string][]*dotEdge)
+	for _, e := range g.Edges {
+		if e.From != e.To {
+			out[e.From] = append(out[e.From], e)
+		}
+	}
+	reversed := make(map[*dotEdge]bool)
+	visiting := make(map[string]bool)
+	visited := make(map[string]bool)
+	var visit func(id string)
+	visit = func(id string) {
+		visiting[id] = true
+		for _, e := range out[id] {
+			switch {
+			case visiting[e.To]:
+				reversed[e] = true
+			case !visited[e.To]:
+				visit(e.To)
+			}
+		}
+		visiting[id] = false
+		visited[id] = true
+	}
+	for _, n := range g.Nodes {
+		if !visited[n.ID] {
+			visit(n.ID)
+		}
+	}
+	var arcs []arc
+	for _, e := range g.Edges {
+		if e.From == e.To {
+			continue
+		}
+		if reversed[e] {
+			arcs = append(arcs, arc{from: e.To, to: e.From, edge: e, reversed: true})
+		} else {
+			arcs = append(arcs, arc{from: e.From, to: e.To, edge: e})
+		}
+	}
+
+	// Longest path layering, in topological order.
+	indegree := make(map[string]int)
+	succ := make(map[string][]arc)
+	for _, a := range arcs {
+		indegree[a.to]++
+		succ[a.from] = append(succ[a.from], a)
+	}
+	var queue []*layoutNode
+	for _, n := range order {
+		if indegree[n.ID] == 0 {
+			queue = append(queue, n)
+		}
+	}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		for _, a := range succ[n.ID] {
+			next := nodes[a.to]
+			next.layer = max(next.layer, n.layer+1)
+			if indegree[a.to]--; indegree[a.to] == 0 {
+				queue = append(queue, next)
+			}
+		}
+	}
+
+	// Split long edges with dummy nodes so every arc joins adjacent layers.
+	type chain struct {
+		edge     *dotEdge
+		reversed bool
+		nodes    []*layoutNode
+	}
+	var chains []chain
+	all := slices.Clone(order)
+	for _, a := range arcs {
+		c := chain{edge: a.edge, reversed: a.reversed, nodes: []*layoutNode{nodes[a.from]}}
+		for l := nodes[a.from].layer + 1; l < nodes[a.to].layer; l++ {
+			d := &layoutNode{dummy: true, layer: l, W: layoutDummy, H: layoutDummy}
+			c.nodes = append(c.nodes, d)
+			all = append(all, d)
+		}
+		c.nodes = append(c.nodes, nodes[a.to])
+		chains = append(chains, c)
+	}
+	up := make(map[*layoutNode][]*layoutNode)
+	down := make(map[*layoutNode][]*layoutNode)
+	for _, c := range chains {
+		for i := 1; i < len(c.nodes); i++ {
+			down[c.nodes[i-1]] = append(down[c.nodes[i-1]], c.nodes[i])
+			up[c.nodes[i]] = append(up[c.nodes[i]], c.nodes[i-1])
+		}
+	}
+
+	var layers [][]*layoutNode
+	for _, n := range all {
+		for len(layers) <= n.layer {
+			layers = append(layers, nil)
+		}
+		layers[n.layer] = append(layers[n.layer], n)
+	}
+	renumber := func(layer []*layoutNode) {
+		for i, n := range layer {
+			n.order = float64(i)
+		}
+	}
+	for _, layer := range layers {
+		renumber(layer)
+	}
+
+	// Crossing reduction: sweep down and up, sorting each layer by the
+	// average position of its neighbors in the previous one.
+	barycenter := func(layer []*layoutNode, neighbors map[*layoutNode][]*layoutNode) {
+		keys := make(map[*layoutNode]float64)
+		for _, n := range layer {
+			keys[n] = n.order
+			if adj := neighbors[n]; len(adj) > 0 {
+				sum := 0.0
+				for _, m := range adj {
+					sum += m.order
+				}
+				keys[n] = sum / float64(len(adj))
+			}
+		}
+		sort.SliceStable(layer, func(a, b int) bool {
+			return keys[layer[a]] < keys[layer[b]]
+		})
+		renumber(layer)
+	}
+	for sweep := 0; sweep < 8; sweep++ {
+		if sweep%2 == 0 {
+			for l := 1; l < len(layers); l++ {
+				barycenter(layers[l], up)
+			}
+		} else {
+			for l := len(layers) - 2; l >= 0; l-- {
+				barycenter(layers[l], down)
+			}
+		}
+	}
+
+	// breadth is the size of a node across its layer, depth along it.
+	breadth := func(n *layoutNode) float64 {
+		if horizontal {
+			return n.H
+		}
+		return n.W
+	}
+	depth := func(n *layoutNode) float64 {
+		if horizontal {
+			return n.W
+		}
+		return n.H
+	}
+
+	// Place nodes side by side, then pull each one towards the average of
+	// its neighbors while keeping the order and separation of the layer.
+	across := make(map[*layoutNode]float64)
+	pack := func(layer []*layoutNode, want map[*layoutNode]float64) {
+		for i, n := range layer {
+			across[n] = want[n]
+			if i > 0 {
+				prev := layer[i-1]
+				minPos := across[prev] + (breadth(prev)+breadth(n))/2 + layoutNodeSep
+				across[n] = max(across[n], minPos)
+			}
+		}
+		shift := 0.0
+		for _, n := range layer {
+			shift += across[n] - want[n]
+		}
+		shift /= float64(len(layer))
+		for _, n := range layer {
+			across[n] -= shift
+		}
+	}
+	for _, layer := range layers {
+		want := make(map[*layoutNode]float64)
+		pos := 0.0
+		for _, n := range layer {
+			want[n] = pos + breadth(n)/2
+			pos += breadth(n) + layoutNodeSep
+		}
+		pack(layer, want)
+	}
+	for iter := 0; iter < 16; iter++ {
+		neighbors := up
+		ls := layers
+		if iter%2 == 1 {
+			neighbors = down
+			ls = slices.Clone(layers)
+			slices.Reverse(ls)
+		}
+		for _, layer := range ls {
+			want := make(map[*layoutNode]float64)
+			for _, n := range layer {
+				want[n] = across[n]
+				adj := neighbors[n]
+				if len(adj) == 0 {
+					continue
+				}
+				sum := 0.0
+				for _, m := range adj {
+					sum += across[m]
+				}
+				want[n] = sum / float64(len(adj))
+			}
+			pack(layer, want)
+		}
+	}
+
+	minAcross := math.Inf(1)
+	maxAcross := math.Inf(-1)
+	for _, n := range all {
+		minAcross = min(minAcross, across[n]-breadth(n)/2)
+		maxAcross = max(maxAcross, across[n]+breadth(n)/2)
+	}
+	along := make([]float64, len(layers))
+	pos := layoutMargin
+	for l, layer := range layers {
+		thickness := 0.0
+		for _, n := range layer {
+			thickness = max(thickness, depth(n))
+		}
+		along[l] = pos + thickness/2
+		pos += thickness + layoutRankSep
+	}
+	totalAlong := pos - layoutRankSep + layoutMargin
+	totalAcross := 2 * layoutMargin
+	if len(all) > 0 {
+		totalAcross += maxAcross - minAcross
+	}
+	for _, n := range all {
+		a := across[n] - minAcross + layoutMargin
+		if horizontal {
+			n.Pos = point{X: along[n.layer], Y: a}
+		} else {
+			n.Pos = point{X: a, Y: along[n.layer]}
+		}
+	}
+
+	result := &layout{Nodes: order}
+	if horizontal {
+		result.Width, result.Height = totalAlong, totalAcross
+	} else {
+		result.Width, result.Height = totalAcross, totalAlong
+	}
+	if len(all) == 0 {
+		result.Width, result.Height = 2*layoutMargin, 2*layoutMargin
+	}
+	for _, c := range chains {
+		var pts []point
+		for _, n := range c.nodes {
+			pts = append(pts, n.Pos)
+		}
+		if c.reversed {
+			slices.Reverse(pts)
+		}
+		from, to := nodes[c.edge.From], nodes[c.edge.To]
+		pts[0] = clipToNode(from, pts[1])
+		pts[len(pts)-1] = clipToNode(to, pts[len(pts)-2])
+		result.Edges = append(result.Edges, &layoutEdge{From: c.edge.From, To: c.edge.To, Attrs: c.edge.Attrs, Points: pts})
+	}
+	for _, e := range g.Edges {
+		if e.From != e.To {
+			continue
+		}
+		// Self loops hang off the right side of the node.
+		n := nodes[e.From]
+		r := point{X: n.Pos.X + n.W/2, Y: n.Pos.Y}
+		result.Edges = append(result.Edges, &layoutEdge{From: e.From, To: e.To, Attrs: e.Attrs, Points: []point{
+			{r.X - 4, r.Y - n.H/4},
+			{r.X + 18, r.Y - n.H/2},
+			{r.X + 18, r.Y + n.H/2},
+			{r.X - 4, r.Y + n.H/4},
+		}})
+		result.Width = max(result.Width, r.X+18+layoutMargin)
+	}
+	boxClusters(g, nodes, result)
+	return result
+}
+
+// boxClusters draws a box around the nodes of every cluster, leaving room
+// for its label above them, and moves the drawing to make room for the boxes
+// when needed. Nodes are not kept together, so boxes may overlap.
+func boxClusters(g *dotGraph, nodes map[string]*layoutNode, l *layout) {
+	for _, c := range g.Clusters {
+		lc := &layoutCluster{
+			ID:    c.ID,
+			Label: c.Attrs["label"],
+			Min:   point{X: math.Inf(1), Y: math.Inf(1)},
+			Max:   point{X: math.Inf(-1), Y: math.Inf(-1)},
+		}
+		for _, n := range g.Nodes {
+			if n.Cluster != c.ID {
+				continue
+			}
+			ln := nodes[n.ID]
+			lc.Min.X = min(lc.Min.X, ln.Pos.X-ln.W/2-layoutMargin)
+			lc.Min.Y = min(lc.Min.Y, ln.Pos.Y-ln.H/2-layoutMargin)
+			lc.Max.X = max(lc.Max.X, ln.Pos.X+ln.W/2+layoutMargin)
+			lc.Max.Y = max(lc.Max.Y, ln.Pos.Y+ln.H/2+layoutMargin)
+		}
+		if math.IsInf(lc.Min.X, 1) {
+			continue
+		}
+		if lc.Label != "" {
+			lc.Min.Y -= layoutFontSize + 2
+			lc.Max.X = max(lc.Max.X, lc.Min.X+textWidth(lc.Label)+2*layoutMargin)
+		}
+		l.Clusters = append(l.Clusters, lc)
+	}
+	var shift point
+	for _, c := range l.Clusters {
+		shift.X = max(shift.X, layoutMargin-c.Min.X)
+		shift.Y = max(shift.Y, layoutMargin-c.Min.Y)
+	}
+	if shift.X > 0 || shift.Y > 0 {
+		for _, n := range l.Nodes {
+			n.Pos = point{X: n.Pos.X + shift.X, Y: n.Pos.Y + shift.Y}
+		}
+		for _, e := range l.Edges {
+			for i, p := range e.Points {
+				e.Points[i] = point{X: p.X + shift.X, Y: p.Y + shift.Y}
+			}
+		}
+		for _, c := range l.Clusters {
+			c.Min = point{X: c.Min.X + shift.X, Y: c.Min.Y + shift.Y}
+			c.Max = point{X: c.Max.X + shift.X, Y: c.Max.Y + shift.Y}
+		}
+		l.Width += shift.X
+		l.Height += shift.Y
+	}
+	for _, c := range l.Clusters {
+		l.Width = max(l.Width, c.Max.X+layoutMargin)
+		l.Height = max(l.Height, c.Max.Y+layoutMargin)
+	}
+}
+
+func nodeLabel(n *dotNode) []string {
+	label, ok := n.Attrs["label"]
+	if !ok || label == `\N` {
+		label = n.ID
+	}
+	label = strings.NewReplacer(`\n`, "\n", `\l`, "\n", `\r`, "\n").Replace(label)
+	return strings.Split(strings.TrimSuffix(label, "\n"), "\n")
+}
+
+// textWidth estimates the width of a line of text in the layout font.
+func textWidth(s string) float64 {
+	w := 0.0
+	for _, r := range s {
+		switch {
+		case r < utf8.RuneSelf && strings.ContainsRune("il.,:;'|!", r):
+			w += 0.3
+		case r < utf8.RuneSelf && r >= 'A' && r <= 'Z', r == 'm', r == 'w':
+			w += 0.72
+		case r < utf8.RuneSelf:
+			w += 0.5
+		default:
+			w += 1
+		}
+	}
+	return w * layoutFontSize
+}
+
+func nodeSize(label []string, attrs map[string]string) (float64, float64) {
+	textW := 0.0
+	for _, line := range label {
+		textW = max(textW, textWidth(line))
+	}
+	textH := float64(len(label)) * (layoutFontSize + 3)
+	if isBox(attrs) {
+		return max(layoutMinWidth, textW+16), max(layoutMinHeight, textH+8)
+	}
+	// An ellipse that contains the text's bounding box.
+	return max(layoutMinWidth, textW*math.Sqrt2+8), max(layoutMinHeight, textH*math.Sqrt2)
+}
+
+func isBox(attrs map[string]string) bool {
+	switch attrs["shape"] {
+	case "box", "rect", "rectangle", "square":
+		return true
+	}
+	return false
+}
+
+// clipToNode returns where the segment from the node's center to p leaves
+// the node's shape.
+func clipToNode(n *layoutNode, p point) point {
+	dx, dy := p.X-n.Pos.X, p.Y-n.Pos.Y
+	if dx == 0 && dy == 0 {
+		return n.Pos
+	}
+	a, b := n.W/2, n.H/2
+	var t float64
+	if isBox(n.Attrs) {
+		t = 1 / max(math.Abs(dx)/a, math.Abs(dy)/b)
+	} else {
+		t = 1 / math.Sqrt(dx*dx/(a*a)+dy*dy/(b*b))
+	}
+	return point{X: n.Pos.X + dx*t, Y: n.Pos.Y + dy*t}
+}
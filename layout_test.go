@@ -0,0 +1,71 @@
+package main
+
+import "testing"
+
+func TestLayoutGraph(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		// ranked are pairs whose head must be laid out after their tail.
+		ranked [][2]string
+	}{
+		{"empty", `digraph { }`, nil},
+		{"single node", `digraph { a }`, nil},
+		{"chain", `digraph { a -> b -> c }`, [][2]string{{"a", "b"}, {"b", "c"}}},
+		{"left to right", `digraph { rankdir="LR"; a -> b -> c }`, [][2]string{{"a", "b"}, {"b", "c"}}},
+		{"long edge", `digraph { a -> b -> c -> d; a -> d }`, [][2]string{{"a", "b"}, {"c", "d"}, {"a", "d"}}},
+		{"diamond", `digraph { a -> {b c} -> d }`, [][2]string{{"a", "b"}, {"a", "c"}, {"b", "d"}, {"c", "d"}}},
+		{"cycle", `digraph { a -> b -> c -> a }`, nil},
+		{"self loop", `digraph { a -> a; a -> b }`, [][2]string{{"a", "b"}}},
+		{"clusters", `digraph { subgraph cluster_x { label="x"; a b } a -> c; b -> c }`, [][2]string{{"a", "c"}}},
+		{"multiline labels", `digraph { a [label="one\ntwo\nthree", shape=box]; a -> b }`, [][2]string{{"a", "b"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := parseDOT(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			l := layoutGraph(g)
+			if len(l.Nodes) != len(g.Nodes) {
+				t.Fatalf("got %v nodes, want %v", len(l.Nodes), len(g.Nodes))
+			}
+			if len(l.Edges) != len(g.Edges) {
+				t.Errorf("got %v edges, want %v", len(l.Edges), len(g.Edges))
+			}
+			pos := make(map[string]*layoutNode)
+			for i, n := range l.Nodes {
+				pos[n.ID] = n
+				if n.Pos.X-n.W/2 < 0 || n.Pos.Y-n.H/2 < 0 || n.Pos.X+n.W/2 > l.Width || n.Pos.Y+n.H/2 > l.Height {
+					t.Errorf("%v at %v (%vx%v) is outside the %vx%v drawing", n.ID, n.Pos, n.W, n.H, l.Width, l.Height)
+				}
+				for _, m := range l.Nodes[:i] {
+					if overlap(n, m) {
+						t.Errorf("%v at %v overlaps %v at %v", n.ID, n.Pos, m.ID, m.Pos)
+					}
+				}
+			}
+			for _, e := range l.Edges {
+				if len(e.Points) < 2 {
+					t.Errorf("edge %v -> %v has %v points", e.From, e.To, len(e.Points))
+				}
+			}
+			horizontal := g.Attrs["rankdir"] == "LR"
+			for _, r := range tt.ranked {
+				from, to := pos[r[0]], pos[r[1]]
+				after := to.Pos.Y > from.Pos.Y
+				if horizontal {
+					after = to.Pos.X > from.Pos.X
+				}
+				if !after {
+					t.Errorf("%v at %v is not laid out after %v at %v", r[1], to.Pos, r[0], from.Pos)
+				}
+			}
+		})
+	}
+}
+
+func overlap(a, b *layoutNode) bool {
+	return a.Pos.X-a.W/2 < b.Pos.X+b.W/2 && b.Pos.X-b.W/2 < a.Pos.X+a.W/2 &&
+		a.Pos.Y-a.H/2 < b.Pos.Y+b.H/2 && b.Pos.Y-b.H/2 < a.Pos.Y+a.H/2
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// sseKeepAlive is how often idle event streams get a comment, so proxies
+// do not close them.
+const sseKeepAlive = 30 * time.Second
+
+// changeFeed tells the pages that have a project open when it changes.
+type changeFeed struct {
+	mu   sync.Mutex
+	subs map[int64]map[chan struct{}]struct{}
+}
+
+// projectChanges is fed by withJournal after every commit.
+var projectChanges = &changeFeed{subs: make(map[int64]map[chan struct{}]struct{})}
+
+// subscribe returns a channel that receives a value after changes to the
+// project, and a function that releases it. Changes that happen while the
+// subscriber is busy are coalesced.
+func (f *changeFeed) subscribe(pID int64) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.subs[pID] == nil {
+		f.subs[pID] = make(map[chan struct{}]struct{})
+	}
+	f.subs[pID][ch] = struct{}{}
+	return ch, func() {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		delete(f.subs[pID], ch)
+		if len(f.subs[pID]) == 0 {
+			delete(f.subs, pID)
+		}
+	}
+}
+
+func (f *changeFeed) publish(pID int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for ch := range f.subs[pID] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// serveChanges streams a "changed" server-sent event every time the
+// project changes, until the client goes away.
+func serveChanges(w http.ResponseWriter, r *http.Request, pID int64) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, http.StatusText(http.StatusInternalServerError)+":streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	changes, release := projectChanges.subscribe(pID)
+	defer release()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case <-changes:
+			fmt.Fprintf(w, "event: changed\ndata: %v\n\n", pID)
+		}
+		flusher.Flush()
+	}
+}
@@ -1,26 +1,32 @@
 package main
 
 import (
-	"bytes"
+	"crypto/subtle"
 	"database/sql"
+	"errors"
+	"flag"
 	"fmt"
 	"html/template"
 	"io"
 	"log"
+	"maps"
 	"net/http"
-	"os/exec"
+	"net/url"
+	"os"
+	"path"
 	"runtime/debug"
-	"sort"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
-	"golang.org/x/exp/maps"
-	"golang.org/x/exp/slices"
 )
 
 type dep struct {
-	Left, Right string
+	Left  string `json:"left"`
+	Right string `json:"right"`
 }
 
 type graph struct {
@@ -28,10 +34,50 @@ type graph struct {
 	Name            string
 	Input           []dep
 	Output          template.HTML
+	Exports         []exportFormat
 	Err             string
 	Src             string
 	AllKnownBubbles []string
+	Bubbles         []bubble
+	States          []bubbleState
 	Vertical        bool
+	View            template.URL
+	Rotate          template.URL
+	Cycles          [][]string
+	Ready           []bubble
+	AbortedUnblocks bool
+	Strict          bool
+	Blocked         *blockedError
+	History         []event
+	UndoLabel       string
+	RedoLabel       string
+	Snapshots       []snapshot
+	Base            *snapshot
+	Changes         projectDiff
+
+	Start             string
+	Gantt             template.HTML
+	Durations         map[string]float64
+	CriticalPath      []string
+	CriticalRemaining float64
+	RemainingWork     float64
+
+	Clusters     bool
+	Tags         []string
+	SelectedTags map[string]bool
+	AllTags      []string
+
+	Role    role
+	Roles   []role
+	Members []member
+
+	// Share is the token of the share link the page was opened with.
+	Share  string
+	Shares []share
+
+	Webhooks     []webhook
+	WebhookKinds []eventKind
+	Deliveries   []delivery
 }
 
 type bubbleState string
@@ -43,6 +89,15 @@ const (
 	aborted bubbleState = "aborted"
 )
 
+var bubbleStates = []bubbleState{initial, started, done, aborted}
+
+func parseBubbleState(s string) (bubbleState, error) {
+	if state := bubbleState(s); slices.Contains(bubbleStates, state) {
+		return state, nil
+	}
+	return "", fmt.Errorf("invalid bubble state %q", s)
+}
+
 func (b bubbleState) color() string {
 	switch b {
 	case started:
@@ -56,187 +111,466 @@ func (b bubbleState) color() string {
 	}
 }
 
+// next is the state /flip moves a bubble to.
+func (b bubbleState) next() bubbleState {
+	switch b {
+	case initial:
+		return started
+	case started:
+		return done
+	case done:
+		return aborted
+	default:
+		return initial
+	}
+}
+
 type bubble struct {
-	Bubble string
-	State  bubbleState
+	Bubble string      `json:"bubble"`
+	State  bubbleState `json:"state"`
 }
 
 type project struct {
-	ID   uint64
-	Name string
+	ID   uint64 `json:"id"`
+	Name string `json:"name"`
+
+	// AbortedUnblocks makes aborted bubbles count as done when deciding
+	// whether their successors are ready to start.
+	AbortedUnblocks bool `json:"aborted_unblocks"`
+
+	// Strict keeps bubbles from being started or done while any of their
+	// predecessors is pending.
+	Strict bool `json:"strict"`
+
+	// Start is the day work on the project begins, as YYYY-MM-DD. The
+	// Gantt chart starts today when it is empty.
+	Start string `json:"start"`
+}
+
+// settings summarizes the project's settings for its history.
+func (p project) settings() string {
+	return fmt.Sprintf("aborted unblocks: %v, strict: %v, start: %v", p.AbortedUnblocks, p.Strict, p.Start)
 }
 
 func main() {
 	log.SetPrefix("bubbleproject: ")
 	log.SetFlags(0)
 
+	flag.String("config", "", "file to read the settings below from, one \"name = value\" per line")
+	dbPath := flag.String("db", "state.db", "path of the SQLite database")
+	listenAddr := flag.String("listen", "localhost:5466", "address to serve on; use 0.0.0.0:5466 to serve on all interfaces")
+	rendererName := flag.String("renderer", "graphviz", "how graphs are drawn: graphviz (runs dot) or builtin (SVG only, no external tools)")
+	dotPath := flag.String("graphviz", "dot", "path of the Graphviz dot command, for the graphviz renderer")
+	formatName := flag.String("format", "png", "format of downloads and copies of the graph that name none: png, svg or pdf (svg with the builtin renderer)")
+	renderCacheSize := flag.Int("render-cache", 128, "how many rendered graphs to keep in memory (0 disables the cache)")
+	logLevelName := flag.String("log-level", "info", "least severe messages to log: debug (also logs every request), info, warn or error")
+	tlsCert := flag.String("tls-cert", "", "TLS certificate file; serves HTTPS when set with -tls-key")
+	tlsKey := flag.String("tls-key", "", "TLS private key file")
+	webhookAllowLocal := flag.Bool("webhook-allow-local", false, "let webhooks post to loopback, private and link-local addresses")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %v:\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintf(flag.CommandLine.Output(), "\nEvery setting can also be given as an environment variable, such as %v for -log-level.\n", envName("log-level"))
+		fmt.Fprintf(flag.CommandLine.Output(), "The command line wins over the environment, which wins over the config file.\n")
+	}
+	flag.Parse()
+	check(loadSettings(flag.CommandLine))
+	var err error
+	currentLogLevel, err = parseLogLevel(*logLevelName)
+	check(err)
+	if (*tlsCert == "") != (*tlsKey == "") {
+		check(errors.New("-tls-cert and -tls-key must be set together"))
+	}
+	// The builtin renderer only draws SVG, so it is its default format.
+	formatGiven := false
+	flag.Visit(func(f *flag.Flag) {
+		formatGiven = formatGiven || f.Name == "format"
+	})
+	if *rendererName == "builtin" && !formatGiven {
+		*formatName = "svg"
+	}
+	defaultFormat, ok := findExportFormat(*formatName)
+	if !ok || !defaultFormat.rendered {
+		check(fmt.Errorf("unknown format %q: use png, svg or pdf", *formatName))
+	}
+	if *rendererName == "builtin" && defaultFormat.Name != "svg" {
+		check(fmt.Errorf("the builtin renderer cannot draw %v: use -format svg", defaultFormat.Name))
+	}
+	graphRenderer, err := newRenderer(*rendererName, *dotPath)
+	check(err)
+	graphRenderer = newCachedRenderer(graphRenderer, *renderCacheSize)
+
 	baseTpl := template.Must(template.New("base").Parse(baseTemplate))
 
 	var dbMu sync.Mutex
-	db, err := sql.Open("sqlite3", "state.db")
+	db, err := sql.Open("sqlite3", *dbPath)
 	check(err)
 	defer func() {
 		check(db.Close())
 	}()
 
-	sqlStmt := `
-	create table if not exists pairs (project bigint, left text, right text);
-	create table if not exists bubbles (project bigint, bubble text, state text);
-	create unique index if not exists bubbles_project_bubble ON bubbles (project, bubble);
-	create table if not exists projects (project integer primary key autoincrement, name text);
-	create unique index if not exists pairs_unique on pairs (project, left, right);
-	`
-	_, err = db.Exec(sqlStmt)
-	check(err)
+	check(migrate(db))
 
-	http.HandleFunc("GET /flip", func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc("POST /flip", func(w http.ResponseWriter, r *http.Request) {
 		dbMu.Lock()
 		defer dbMu.Unlock()
-		pID := r.URL.Query().Get("pID")
-		if _, err := db.Exec(`
-			insert into bubbles (project, bubble, state) values (?, ?, 'started')
-				on conflict (project, bubble) do update set
-				state = case
-				when state = '' then 'initial'
-				when state = 'initial' then 'started'
-				when state = 'started' then 'done'
-				when state = 'done' then 'aborted'
-				when state = 'aborted' then 'initial'
-				else 'initial'
-				end
-		`, pID, r.URL.Query().Get("bubble")); err != nil {
-			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
+		pID, err := projectID(r)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, ok := authorize(w, r, db, pID, editor); !ok {
 			return
 		}
+		bubble := r.URL.Query().Get("bubble")
 		seeOtherURL := fmt.Sprintf("/projects?pID=%v", pID)
-		if r.URL.Query().Has("vertical") {
-			seeOtherURL += "&vertical"
+		var blockedErr *blockedError
+		err = withJournal(db, actorOf(r), func(j *journal) error {
+			_, err := flipBubble(j, pID, bubble)
+			return err
+		})
+		if errors.As(err, &blockedErr) {
+			seeOtherURL += "&blocked=" + url.QueryEscape(bubble)
+		} else if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
+			return
 		}
-		http.Redirect(w, r, seeOtherURL, http.StatusSeeOther)
+		seeOtherURL += viewQuery(r.URL.Query())
+		w.Header().Set("HX-Location", seeOtherURL)
 	})
 
-	http.HandleFunc("DELETE /remove", func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc("POST /state", func(w http.ResponseWriter, r *http.Request) {
+		pID, err := projectID(r)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
 		dbMu.Lock()
 		defer dbMu.Unlock()
-		pID := r.URL.Query().Get("pID")
-		_, err := db.Exec("delete from pairs where left = ? and right = ? and project = ?", r.URL.Query().Get("left"), r.URL.Query().Get("right"), pID)
+		if _, ok := authorize(w, r, db, pID, editor); !ok {
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		state, err := parseBubbleState(r.PostForm.Get("state"))
 		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		bubble := r.URL.Query().Get("bubble")
+		if _, err := loadBubbleDocument(db, pID, bubble); errors.Is(err, errNotFound) {
+			http.Error(w, http.StatusText(http.StatusNotFound)+":"+err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
 			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
 			return
 		}
-		seeOtherURL := fmt.Sprintf("/projects?pID=%v", pID)
-		if r.URL.Query().Has("vertical") {
-			seeOtherURL += "&vertical"
+		var blockedErr *blockedError
+		err = withJournal(db, actorOf(r), func(j *journal) error {
+			return changeBubbleState(j, pID, bubble, state)
+		})
+		if errors.As(err, &blockedErr) {
+			http.Error(w, http.StatusText(http.StatusConflict)+":"+err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
+			return
 		}
+		seeOtherURL := fmt.Sprintf("/projects?pID=%v", pID)
+		seeOtherURL += viewQuery(r.URL.Query())
 		w.Header().Set("HX-Location", seeOtherURL)
 	})
 
-	http.HandleFunc("POST /rename", func(w http.ResponseWriter, r *http.Request) {
-		pID := r.URL.Query().Get("pID")
+	http.HandleFunc("POST /duration", func(w http.ResponseWriter, r *http.Request) {
+		pID, err := projectID(r)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
 		dbMu.Lock()
 		defer dbMu.Unlock()
+		if _, ok := authorize(w, r, db, pID, editor); !ok {
+			return
+		}
 		if err := r.ParseForm(); err != nil {
 			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
 			return
 		}
-		tx, err := db.Begin()
+		d, err := parseDuration(r.PostForm.Get("duration"))
 		if err != nil {
-			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
 			return
 		}
-		if _, err := tx.Exec("update pairs set left = ? where project = ? and left = ?", r.PostForm.Get("to"), pID, r.PostForm.Get("from")); err != nil {
+		bubble := r.URL.Query().Get("bubble")
+		if _, err := loadBubbleDocument(db, pID, bubble); errors.Is(err, errNotFound) {
+			http.Error(w, http.StatusText(http.StatusNotFound)+":"+err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
 			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
 			return
 		}
-		if _, err := tx.Exec("update pairs set right = ? where project = ? and right = ?", r.PostForm.Get("to"), pID, r.PostForm.Get("from")); err != nil {
+		err = withJournal(db, actorOf(r), func(j *journal) error {
+			return changeBubbleDuration(j, pID, bubble, d)
+		})
+		if err != nil {
 			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
 			return
 		}
-		if _, err := tx.Exec("update bubbles set bubble = ? where project = ? and bubble = ?", r.PostForm.Get("to"), pID, r.PostForm.Get("from")); err != nil {
-			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
+		seeOtherURL := fmt.Sprintf("/projects?pID=%v", pID)
+		seeOtherURL += viewQuery(r.URL.Query())
+		w.Header().Set("HX-Location", seeOtherURL)
+	})
+
+	http.HandleFunc("DELETE /remove", func(w http.ResponseWriter, r *http.Request) {
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		pID, err := projectID(r)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, ok := authorize(w, r, db, pID, editor); !ok {
 			return
 		}
-		if err := tx.Commit(); err != nil {
+		err = withJournal(db, actorOf(r), func(j *journal) error {
+			_, err := removePair(j, pID, r.URL.Query().Get("left"), r.URL.Query().Get("right"))
+			return err
+		})
+		if err != nil {
 			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
 			return
 		}
 		seeOtherURL := fmt.Sprintf("/projects?pID=%v", pID)
-		if r.URL.Query().Has("vertical") {
-			seeOtherURL += "&vertical"
+		seeOtherURL += viewQuery(r.URL.Query())
+		w.Header().Set("HX-Location", seeOtherURL)
+	})
+
+	http.HandleFunc("POST /rename", func(w http.ResponseWriter, r *http.Request) {
+		pID, err := projectID(r)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		if _, ok := authorize(w, r, db, pID, editor); !ok {
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = withJournal(db, actorOf(r), func(j *journal) error {
+			return renameBubble(j, pID, r.PostForm.Get("from"), r.PostForm.Get("to"))
+		})
+		if errors.Is(err, errNotFound) {
+			http.Error(w, http.StatusText(http.StatusNotFound)+":"+err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
+			return
 		}
+		seeOtherURL := fmt.Sprintf("/projects?pID=%v", pID)
+		seeOtherURL += viewQuery(r.URL.Query())
 		w.Header().Set("HX-Location", seeOtherURL)
 	})
 
 	http.HandleFunc("POST /delete", func(w http.ResponseWriter, r *http.Request) {
-		pID := r.URL.Query().Get("pID")
+		pID, err := projectID(r)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
 		dbMu.Lock()
 		defer dbMu.Unlock()
+		if _, ok := authorize(w, r, db, pID, editor); !ok {
+			return
+		}
 		if err := r.ParseForm(); err != nil {
 			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
 			return
 		}
-		tx, err := db.Begin()
+		err = withJournal(db, actorOf(r), func(j *journal) error {
+			return deleteBubble(j, pID, r.PostForm.Get("activity"))
+		})
 		if err != nil {
 			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
 			return
 		}
-		if _, err := tx.Exec("delete from pairs where project = ? and (left = ? or right = ?)", pID, r.PostForm.Get("activity"), r.PostForm.Get("activity")); err != nil {
-			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
+		seeOtherURL := fmt.Sprintf("/projects?pID=%v", pID)
+		seeOtherURL += viewQuery(r.URL.Query())
+		w.Header().Set("HX-Location", seeOtherURL)
+	})
+
+	http.HandleFunc("POST /store", func(w http.ResponseWriter, r *http.Request) {
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		pID, err := projectID(r)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, ok := authorize(w, r, db, pID, editor); !ok {
 			return
 		}
-		if err := tx.Commit(); err != nil {
+		newCenter := strings.TrimSpace(r.PostForm.Get("newCenter"))
+		newLeft := strings.TrimSpace(r.PostForm.Get("newLeft"))
+		newRight := strings.TrimSpace(r.PostForm.Get("newRight"))
+		err = withJournal(db, actorOf(r), func(j *journal) error {
+			if newCenter != "" && newRight != "" {
+				if _, err := insertPair(j, pID, newCenter, newRight); err != nil {
+					return err
+				}
+			}
+			if newLeft != "" && newCenter != "" {
+				if _, err := insertPair(j, pID, newLeft, newCenter); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		var cycleErr *cycleError
+		if errors.As(err, &cycleErr) {
+			http.Error(w, http.StatusText(http.StatusConflict)+":"+err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
 			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
 			return
 		}
+
 		seeOtherURL := fmt.Sprintf("/projects?pID=%v", pID)
-		if r.URL.Query().Has("vertical") {
-			seeOtherURL += "&vertical"
-		}
+		seeOtherURL += viewQuery(r.URL.Query())
 		w.Header().Set("HX-Location", seeOtherURL)
 	})
 
-	http.HandleFunc("POST /store", func(w http.ResponseWriter, r *http.Request) {
+	http.HandleFunc("POST /settings", func(w http.ResponseWriter, r *http.Request) {
+		pID, err := projectID(r)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
 		dbMu.Lock()
 		defer dbMu.Unlock()
+		if _, ok := authorize(w, r, db, pID, editor); !ok {
+			return
+		}
 		if err := r.ParseForm(); err != nil {
 			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
 			return
 		}
-		tx, err := db.Begin()
+		p, err := loadProject(db, pID)
 		if err != nil {
 			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
 			return
 		}
-		stmt, err := tx.Prepare("insert into pairs (project, left, right) values (?, ?, ?) on conflict (project, left, right) do nothing")
+		p.AbortedUnblocks = r.PostForm.Has("abortedUnblocks")
+		p.Strict = r.PostForm.Has("strict")
+		if p.Start, err = parseStartDate(r.PostForm.Get("start")); err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = withJournal(db, actorOf(r), func(j *journal) error {
+			return saveProject(j, p)
+		})
 		if err != nil {
 			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
 			return
 		}
-		pID := r.URL.Query().Get("pID")
-		newCenter := strings.TrimSpace(r.PostForm.Get("newCenter"))
-		newLeft := strings.TrimSpace(r.PostForm.Get("newLeft"))
-		newRight := strings.TrimSpace(r.PostForm.Get("newRight"))
-		if pID != "" && newCenter != "" && newRight != "" {
-			if _, err := stmt.Exec(pID, newCenter, newRight); err != nil {
-				http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
+		seeOtherURL := fmt.Sprintf("/projects?pID=%v", pID)
+		seeOtherURL += viewQuery(r.URL.Query())
+		w.Header().Set("HX-Location", seeOtherURL)
+	})
+
+	for _, stack := range []string{undoStack, redoStack} {
+		move := undo
+		if stack == redoStack {
+			move = redo
+		}
+		http.HandleFunc("POST /"+stack, func(w http.ResponseWriter, r *http.Request) {
+			pID, err := projectID(r)
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
 				return
 			}
-		}
-		if pID != "" && newLeft != "" && newCenter != "" {
-			if _, err := stmt.Exec(pID, newLeft, newCenter); err != nil {
+			dbMu.Lock()
+			defer dbMu.Unlock()
+			if _, ok := authorize(w, r, db, pID, editor); !ok {
+				return
+			}
+			err = withJournal(db, actorOf(r), func(j *journal) error {
+				_, err := move(j, pID)
+				return err
+			})
+			if errors.Is(err, errNothingToUndo) || errors.Is(err, errNothingToRedo) {
+				http.Error(w, http.StatusText(http.StatusConflict)+":"+err.Error(), http.StatusConflict)
+				return
+			} else if err != nil {
 				http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
 				return
 			}
+			seeOtherURL := fmt.Sprintf("/projects?pID=%v", pID)
+			seeOtherURL += viewQuery(r.URL.Query())
+			w.Header().Set("HX-Location", seeOtherURL)
+		})
+	}
+
+	http.HandleFunc("POST /snapshots", func(w http.ResponseWriter, r *http.Request) {
+		pID, err := projectID(r)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		if _, ok := authorize(w, r, db, pID, editor); !ok {
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		name := strings.TrimSpace(r.PostForm.Get("name"))
+		if name == "" {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":snapshot name is required", http.StatusBadRequest)
+			return
 		}
-		if err := tx.Commit(); err != nil {
+		if _, err := createSnapshot(db, pID, name); err != nil {
 			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
 			return
 		}
-
 		seeOtherURL := fmt.Sprintf("/projects?pID=%v", pID)
-		if r.URL.Query().Has("vertical") {
-			seeOtherURL += "&vertical"
+		seeOtherURL += viewQuery(r.URL.Query())
+		w.Header().Set("HX-Location", seeOtherURL)
+	})
+
+	http.HandleFunc("DELETE /snapshots", func(w http.ResponseWriter, r *http.Request) {
+		pID, err := projectID(r)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		sID, err := strconv.ParseInt(r.URL.Query().Get("snapshot"), 10, 64)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		if _, ok := authorize(w, r, db, pID, editor); !ok {
+			return
+		}
+		if _, err := deleteSnapshot(db, pID, sID); err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
+			return
 		}
+		seeOtherURL := fmt.Sprintf("/projects?pID=%v", pID)
+		seeOtherURL += viewQuery(r.URL.Query())
 		w.Header().Set("HX-Location", seeOtherURL)
 	})
 
@@ -245,16 +579,82 @@ func main() {
 			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
 			return
 		}
+		acct, ok := signedIn(w, r)
+		if !ok {
+			return
+		}
 		name := r.FormValue("name")
 		dbMu.Lock()
 		defer dbMu.Unlock()
-		result, err := db.Exec(`insert into projects (name) values (?)`, name)
+		var pID int64
+		err := withJournal(db, actorOf(r), func(j *journal) error {
+			var err error
+			if pID, err = createProject(j, name); err != nil {
+				return err
+			}
+			return setMember(j, pID, acct.ID, owner)
+		})
 		if err != nil {
 			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
 			return
 		}
-		pID, err := result.LastInsertId()
+		seeOtherURL := fmt.Sprintf("/projects?pID=%v", pID)
+		w.Header().Set("HX-Location", seeOtherURL)
+	})
+
+	http.HandleFunc("POST /projects/import", func(w http.ResponseWriter, r *http.Request) {
+		acct, ok := signedIn(w, r)
+		if !ok {
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		src := r.FormValue("src")
+		name := r.FormValue("name")
+		if f, header, err := r.FormFile("file"); err == nil {
+			defer f.Close()
+			buf, err := io.ReadAll(f)
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+				return
+			}
+			src = string(buf)
+			if name == "" {
+				name = strings.TrimSuffix(header.Filename, path.Ext(header.Filename))
+			}
+		} else if !errors.Is(err, http.ErrMissingFile) {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		g, err := parseDOT(src)
 		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if name == "" {
+			name = g.ID
+		}
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		var pID int64
+		err = withJournal(db, actorOf(r), func(j *journal) error {
+			var err error
+			if pID, err = importDOT(j, name, g); err != nil {
+				return err
+			}
+			return setMember(j, pID, acct.ID, owner)
+		})
+		var cycleErr *cycleError
+		if errors.Is(err, errUndirectedGraph) {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		} else if errors.As(err, &cycleErr) {
+			http.Error(w, http.StatusText(http.StatusConflict)+":"+err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
 			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -262,206 +662,993 @@ func main() {
 		w.Header().Set("HX-Location", seeOtherURL)
 	})
 
+	http.HandleFunc("GET /projects/events", func(w http.ResponseWriter, r *http.Request) {
+		pID, err := projectID(r)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		dbMu.Lock()
+		_, ok := authorize(w, r, db, pID, viewer)
+		dbMu.Unlock()
+		if !ok {
+			return
+		}
+		serveChanges(w, r, pID)
+	})
+
 	http.HandleFunc("DELETE /projects", func(w http.ResponseWriter, r *http.Request) {
 		dbMu.Lock()
 		defer dbMu.Unlock()
-		pID := r.URL.Query().Get("pID")
-		if _, err := db.Exec("DELETE FROM pairs WHERE project = ?", pID); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		pID, err := projectID(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, ok := authorize(w, r, db, pID, owner); !ok {
+			return
+		}
+		err = withJournal(db, actorOf(r), func(j *journal) error {
+			return deleteProject(j, pID)
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("HX-Refresh", "true")
+		w.WriteHeader(http.StatusOK)
+	})
+
+	renderProjectTpl := template.Must(template.Must(baseTpl.Clone()).New("content").Parse(renderProjectTemplate))
+	sharedProjectTpl := template.Must(template.Must(renderProjectTpl.Clone()).Parse(`{{ define "nav" }}<ul></ul>{{ end }}`))
+
+	http.HandleFunc("GET /bubble", func(w http.ResponseWriter, r *http.Request) {
+		pID, err := projectID(r)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		have, ok := authorize(w, r, db, pID, viewer)
+		if !ok {
+			return
+		}
+		name := r.URL.Query().Get("bubble")
+		info, err := bubbleInfoOf(db, pID, name)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err = renderProjectTpl.ExecuteTemplate(w, "bubble-panel", struct {
+			PID    string
+			Bubble string
+			View   template.URL
+			Info   bubbleInfo
+			Role   role
+		}{strconv.FormatInt(pID, 10), name, template.URL(viewQuery(r.URL.Query())), info, have})
+		if err != nil {
+			log.Printf("cannot execute template: %v", err)
+		}
+	})
+
+	http.HandleFunc("POST /bubble", func(w http.ResponseWriter, r *http.Request) {
+		pID, err := projectID(r)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		if _, ok := authorize(w, r, db, pID, editor); !ok {
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		info, err := parseBubbleInfo(r.PostForm.Get("description"), r.PostForm.Get("assignee"), r.PostForm.Get("due"), r.PostForm.Get("url"), parseTags(r.PostForm.Get("tags")))
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		bubble := r.URL.Query().Get("bubble")
+		if _, err := loadBubbleDocument(db, pID, bubble); errors.Is(err, errNotFound) {
+			http.Error(w, http.StatusText(http.StatusNotFound)+":"+err.Error(), http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		err = withJournal(db, actorOf(r), func(j *journal) error {
+			return changeBubbleInfo(j, pID, bubble, info)
+		})
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		seeOtherURL := fmt.Sprintf("/projects?pID=%v", pID)
+		seeOtherURL += viewQuery(r.URL.Query())
+		w.Header().Set("HX-Location", seeOtherURL)
+	})
+
+	http.HandleFunc("POST /csv", func(w http.ResponseWriter, r *http.Request) {
+		pID, err := projectID(r)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		var in csvImport
+		if f, _, err := r.FormFile("pairs"); err == nil {
+			defer f.Close()
+			if in.Pairs, err = readPairsCSV(f); err != nil {
+				http.Error(w, http.StatusText(http.StatusBadRequest)+":pairs: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if f, _, err := r.FormFile("bubbles"); err == nil {
+			defer f.Close()
+			if in.States, err = readBubblesCSV(f); err != nil {
+				http.Error(w, http.StatusText(http.StatusBadRequest)+":bubbles: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		replace := r.FormValue("mode") == "replace"
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		if _, ok := authorize(w, r, db, pID, editor); !ok {
+			return
+		}
+		var cycleErr *cycleError
+		if r.URL.Query().Has("preview") {
+			current, err := captureState(db, pID)
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			target, err := planCSVImport(current, in, replace)
+			if errors.As(err, &cycleErr) {
+				http.Error(w, http.StatusText(http.StatusConflict)+":"+err.Error(), http.StatusConflict)
+				return
+			} else if err != nil {
+				http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			if err := renderProjectTpl.ExecuteTemplate(w, "csv-preview", diffStates(current, target)); err != nil {
+				log.Printf("cannot execute template: %v", err)
+			}
+			return
+		}
+		err = withJournal(db, actorOf(r), func(j *journal) error {
+			_, err := importCSV(j, pID, in, replace)
+			return err
+		})
+		if errors.As(err, &cycleErr) {
+			http.Error(w, http.StatusText(http.StatusConflict)+":"+err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		seeOtherURL := fmt.Sprintf("/projects?pID=%v", pID)
+		seeOtherURL += viewQuery(r.URL.Query())
+		w.Header().Set("HX-Location", seeOtherURL)
+	})
+	http.HandleFunc("POST /dsl", func(w http.ResponseWriter, r *http.Request) {
+		pID, err := projectID(r)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		// Mistakes are listed under the text area, which keeps its content.
+		showErrors := func(err error) {
+			var msgs []string
+			if joined, ok := err.(interface{ Unwrap() []error }); ok {
+				for _, err := range joined.Unwrap() {
+					msgs = append(msgs, err.Error())
+				}
+			} else {
+				msgs = append(msgs, err.Error())
+			}
+			w.Header().Set("HX-Retarget", "#dsl-errors")
+			w.Header().Set("HX-Reswap", "innerHTML")
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			if err := renderProjectTpl.ExecuteTemplate(w, "dsl-errors", msgs); err != nil {
+				log.Printf("cannot execute template: %v", err)
+			}
+		}
+		script, err := parseDSL(r.PostForm.Get("dsl"))
+		if err != nil {
+			showErrors(err)
+			return
+		}
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		if _, ok := authorize(w, r, db, pID, editor); !ok {
+			return
+		}
+		err = withJournal(db, actorOf(r), func(j *journal) error {
+			return applyDSL(j, pID, script)
+		})
+		var dslErr *dslError
+		if errors.As(err, &dslErr) {
+			showErrors(err)
+			return
+		} else if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		seeOtherURL := fmt.Sprintf("/projects?pID=%v", pID)
+		seeOtherURL += viewQuery(r.URL.Query())
+		w.Header().Set("HX-Location", seeOtherURL)
+	})
+
+	http.HandleFunc("GET /projects", func(w http.ResponseWriter, r *http.Request) {
+		pID, err := projectID(r)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		have, ok := authorize(w, r, db, pID, viewer)
+		if !ok {
+			return
+		}
+
+		current, err := captureState(db, pID)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		p, deps, states := current.Project, current.Pairs, current.States
+		opts := dotOptions{
+			Vertical:  r.URL.Query().Has("vertical"),
+			Durations: current.Durations,
+			Info:      current.Info,
+			Clusters:  r.URL.Query().Has("clusters"),
+			Tags:      normalizeTags(r.URL.Query()["tag"]),
+			ReadOnly:  !have.CanEdit(),
+		}
+		var (
+			base    *snapshot
+			changes projectDiff
+		)
+		if v := r.URL.Query().Get("diff"); v != "" {
+			sID, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+				return
+			}
+			s, err := loadSnapshot(db, pID, sID)
+			if errors.Is(err, errNotFound) {
+				http.Error(w, http.StatusText(http.StatusNotFound)+":snapshot not found", http.StatusNotFound)
+				return
+			} else if err != nil {
+				http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			base = &s
+			opts.Base = s.State
+			changes = diffStates(*s.State, current)
+		}
+		src := buildDOT(p, deps, states, opts)
+
+		if r.URL.Query().Has("download") {
+			name := r.URL.Query().Get("format")
+			if name == "" {
+				name = defaultFormat.Name
+			}
+			format, ok := findExportFormat(name)
+			if !ok {
+				http.Error(w, http.StatusText(http.StatusBadRequest)+":unknown format "+strconv.Quote(name), http.StatusBadRequest)
+				return
+			}
+			out, err := exportProject(r.Context(), graphRenderer, format, current, src, opts.Vertical)
+			if errors.Is(err, errUnsupportedFormat) && r.URL.Query().Get("format") == "" {
+				// The built-in renderer cannot draw PNG; plain downloads
+				// fall back to SVG.
+				format, _ = findExportFormat("svg")
+				out, err = exportProject(r.Context(), graphRenderer, format, current, src, opts.Vertical)
+			}
+			if errors.Is(err, errUnsupportedFormat) {
+				http.Error(w, http.StatusText(http.StatusNotImplemented)+":"+err.Error(), http.StatusNotImplemented)
+				return
+			} else if err != nil {
+				http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", format.ContentType)
+			w.Header().Set("Content-Disposition", `attachment; filename="`+format.Filename+`"`)
+			if _, err := w.Write(out); err != nil {
+				log.Println(err)
+			}
+			return
+		}
+
+		var renderErr string
+		out, err := graphRenderer.render(r.Context(), src, "svg")
+		if err != nil {
+			renderErr = "\n" + err.Error()
+		}
+		var blocked *blockedError
+		if name := r.URL.Query().Get("blocked"); name != "" {
+			state, err := bubbleStateOf(db, pID, name)
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if blockers := blockersOf(deps, states, name, p.AbortedUnblocks); len(blockers) > 0 {
+				blocked = &blockedError{Bubble: name, State: state.next(), Blockers: blockers}
+			}
+		}
+		bubbles := knownBubbles(deps, states)
+		history, err := loadEvents(db, pID, historySize)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		undoLabel, err := peekState(db, pID, undoStack)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		redoLabel, err := peekState(db, pID, redoStack)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		snapshots, err := listSnapshots(db, pID)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		members, err := listMembers(db, pID)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		shareToken := r.URL.Query().Get("share")
+		var (
+			shares     []share
+			webhooks   []webhook
+			deliveries []delivery
+		)
+		if have.CanManage() && shareToken == "" {
+			if shares, err = listShares(db, pID); err != nil {
+				http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if webhooks, err = listWebhooks(db, pID); err != nil {
+				http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if deliveries, err = listDeliveries(db, pID, deliveriesShown); err != nil {
+				http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		critical, criticalRemaining := criticalPath(deps, states, current.Durations)
+		var gantt template.HTML
+		if tasks, ok := schedule(deps, states, current.Durations); ok && len(current.Durations) > 0 {
+			gantt = template.HTML(renderGantt(tasks, projectStart(p, time.Now())))
+		}
+		rotated := maps.Clone(r.URL.Query())
+		if opts.Vertical {
+			rotated.Del("vertical")
+		} else {
+			rotated.Set("vertical", "")
+		}
+		selectedTags := make(map[string]bool)
+		for _, tag := range opts.Tags {
+			selectedTags[tag] = true
+		}
+		var allKnownBubblesList []string
+		for _, bubble := range bubbles {
+			allKnownBubblesList = append(allKnownBubblesList, bubble.Bubble)
+		}
+		tpl := renderProjectTpl
+		if shareToken != "" {
+			tpl = sharedProjectTpl
+		}
+		err = tpl.ExecuteTemplate(w, "base", graph{
+			PID:             strconv.FormatInt(pID, 10),
+			Name:            p.Name,
+			Input:           deps,
+			Output:          template.HTML(out),
+			Exports:         exportFormats,
+			Err:             renderErr,
+			Src:             src,
+			AllKnownBubbles: allKnownBubblesList,
+			Bubbles:         bubbles,
+			States:          bubbleStates,
+			Vertical:        opts.Vertical,
+			View:            template.URL(viewQuery(r.URL.Query())),
+			Rotate:          template.URL(viewQuery(rotated)),
+			Cycles:          findCycles(deps),
+			Ready:           readyBubbles(deps, states, p.AbortedUnblocks),
+			AbortedUnblocks: p.AbortedUnblocks,
+			Strict:          p.Strict,
+			Blocked:         blocked,
+			History:         history,
+			UndoLabel:       undoLabel,
+			RedoLabel:       redoLabel,
+			Snapshots:       snapshots,
+			Base:            base,
+			Changes:         changes,
+
+			Start:             p.Start,
+			Gantt:             gantt,
+			Durations:         current.Durations,
+			CriticalPath:      critical,
+			CriticalRemaining: criticalRemaining,
+			RemainingWork:     remainingWork(deps, states, current.Durations),
+
+			Clusters:     opts.Clusters,
+			Tags:         opts.Tags,
+			SelectedTags: selectedTags,
+			AllTags:      projectTags(current.Info),
+
+			Role:    have,
+			Roles:   roles,
+			Members: members,
+
+			Share:  shareToken,
+			Shares: shares,
+
+			Webhooks:     webhooks,
+			WebhookKinds: webhookKinds,
+			Deliveries:   deliveries,
+		})
+		if err != nil {
+			log.Printf("cannot execute template: %v", err)
+		}
+	})
+
+	boardTpl := template.Must(template.Must(baseTpl.Clone()).New("content").Parse(boardTemplate))
+	http.HandleFunc("GET /board", func(w http.ResponseWriter, r *http.Request) {
+		pID, err := projectID(r)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		have, ok := authorize(w, r, db, pID, viewer)
+		if !ok {
+			return
+		}
+		current, err := captureState(db, pID)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		err = boardTpl.ExecuteTemplate(w, "base", struct {
+			PID     string
+			Name    string
+			Columns []boardColumn
+			Role    role
+		}{strconv.FormatInt(pID, 10), current.Project.Name, buildBoard(current), have})
+		if err != nil {
+			log.Printf("cannot execute template: %v", err)
+		}
+	})
+
+	loginTpl := template.Must(template.Must(baseTpl.Clone()).New("content").Parse(loginTemplate))
+	for _, page := range []string{"login", "signup"} {
+		http.HandleFunc("GET /"+page, func(w http.ResponseWriter, r *http.Request) {
+			err := loginTpl.ExecuteTemplate(w, "base", struct {
+				Signup bool
+				Next   string
+			}{page == "signup", localRedirect(r.URL.Query().Get("next"))})
+			if err != nil {
+				log.Printf("cannot execute template: %v", err)
+			}
+		})
+	}
+
+	http.HandleFunc("POST /login", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		acct, err := checkPassword(db, r.PostForm.Get("name"), r.PostForm.Get("password"))
+		if errors.Is(err, errBadLogin) {
+			http.Error(w, http.StatusText(http.StatusUnauthorized)+":"+err.Error(), http.StatusUnauthorized)
+			return
+		} else if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		cookie, err := startSession(db, r, acct.ID)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.SetCookie(w, cookie)
+		w.Header().Set("HX-Redirect", localRedirect(r.PostForm.Get("next")))
+	})
+
+	http.HandleFunc("POST /signup", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		acct, err := createUser(db, r.PostForm.Get("name"), r.PostForm.Get("password"))
+		if errors.Is(err, errInvalidLogin) {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		} else if errors.Is(err, errNameTaken) {
+			http.Error(w, http.StatusText(http.StatusConflict)+":"+err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		cookie, err := startSession(db, r, acct.ID)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.SetCookie(w, cookie)
+		w.Header().Set("HX-Redirect", localRedirect(r.PostForm.Get("next")))
+	})
+
+	http.HandleFunc("POST /logout", func(w http.ResponseWriter, r *http.Request) {
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		cookie, err := endSession(db, r)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.SetCookie(w, cookie)
+		w.Header().Set("HX-Redirect", "/login")
+	})
+
+	// claimToken lets whoever can read the server's log take over the
+	// projects made before there were accounts. It works once.
+	var claimToken string
+	if n, err := unclaimedProjects(db); err != nil {
+		check(err)
+	} else if n > 0 {
+		claimToken, err = newToken()
+		check(err)
+		log.Printf("%v projects have no owner; sign in and open /claim?token=%v to own them", n, claimToken)
+	}
+	claimTpl := template.Must(template.Must(baseTpl.Clone()).New("content").Parse(claimTemplate))
+	http.HandleFunc("GET /claim", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := signedIn(w, r); !ok {
+			return
+		}
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		n, err := unclaimedProjects(db)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		err = claimTpl.ExecuteTemplate(w, "base", struct {
+			Token string
+			Count int
+		}{r.URL.Query().Get("token"), n})
+		if err != nil {
+			log.Printf("cannot execute template: %v", err)
+		}
+	})
+
+	http.HandleFunc("POST /claim", func(w http.ResponseWriter, r *http.Request) {
+		acct, ok := signedIn(w, r)
+		if !ok {
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		token := r.PostForm.Get("token")
+		if claimToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(claimToken)) != 1 {
+			http.Error(w, http.StatusText(http.StatusForbidden)+":"+errBadClaim.Error(), http.StatusForbidden)
+			return
+		}
+		n, err := claimProjects(db, acct.ID)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		claimToken = ""
+		log.Printf("%v took over %v projects", acct.Name, n)
+		w.Header().Set("HX-Redirect", "/")
+	})
+
+	http.HandleFunc("POST /members", func(w http.ResponseWriter, r *http.Request) {
+		pID, err := projectID(r)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		if _, ok := authorize(w, r, db, pID, owner); !ok {
+			return
+		}
+		newRole, err := parseRole(r.PostForm.Get("role"))
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		// The role select of a member names them by ID in the URL; the form
+		// adding a member names them in the body.
+		var userID int64
+		if v := r.URL.Query().Get("user"); v != "" {
+			userID, err = strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if have, err := roleOf(db, userID, pID); err != nil {
+				http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
+				return
+			} else if have == "" {
+				http.Error(w, http.StatusText(http.StatusNotFound)+":"+errNotFound.Error(), http.StatusNotFound)
+				return
+			}
+		} else {
+			acct, err := findUser(db, r.PostForm.Get("name"))
+			if errors.Is(err, errNotFound) {
+				http.Error(w, http.StatusText(http.StatusNotFound)+":"+err.Error(), http.StatusNotFound)
+				return
+			} else if err != nil {
+				http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			userID = acct.ID
+		}
+		err = setMember(db, pID, userID, newRole)
+		if errors.Is(err, errLastOwner) {
+			http.Error(w, http.StatusText(http.StatusConflict)+":"+err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("HX-Location", fmt.Sprintf("/projects?pID=%v", pID))
+	})
+
+	http.HandleFunc("DELETE /members", func(w http.ResponseWriter, r *http.Request) {
+		pID, err := projectID(r)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		userID, err := strconv.ParseInt(r.URL.Query().Get("user"), 10, 64)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		if _, ok := authorize(w, r, db, pID, owner); !ok {
+			return
+		}
+		err = removeMember(db, pID, userID)
+		if errors.Is(err, errLastOwner) {
+			http.Error(w, http.StatusText(http.StatusConflict)+":"+err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("HX-Location", fmt.Sprintf("/projects?pID=%v", pID))
+	})
+
+	http.HandleFunc("POST /webhooks", func(w http.ResponseWriter, r *http.Request) {
+		pID, err := projectID(r)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		kinds, err := parseWebhookKinds(r.PostForm["event"])
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		if _, ok := authorize(w, r, db, pID, owner); !ok {
+			return
+		}
+		_, err = createWebhook(db, pID, r.PostForm.Get("url"), kinds)
+		if errors.Is(err, errWebhookURL) || errors.Is(err, errNoWebhookKinds) {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("HX-Location", fmt.Sprintf("/projects?pID=%v", pID))
+	})
+
+	http.HandleFunc("DELETE /webhooks", func(w http.ResponseWriter, r *http.Request) {
+		pID, err := projectID(r)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		hID, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		if _, ok := authorize(w, r, db, pID, owner); !ok {
+			return
+		}
+		found, err := deleteWebhook(db, pID, hID)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !found {
+			http.Error(w, http.StatusText(http.StatusNotFound)+":webhook not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("HX-Location", fmt.Sprintf("/projects?pID=%v", pID))
+	})
+
+	http.HandleFunc("POST /shares", func(w http.ResponseWriter, r *http.Request) {
+		pID, err := projectID(r)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		if _, ok := authorize(w, r, db, pID, owner); !ok {
+			return
+		}
+		s, token, err := createShare(db, pID, strings.TrimSpace(r.PostForm.Get("name")))
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		link := fmt.Sprintf("%v://%v/projects?pID=%v&share=%v", scheme, r.Host, pID, url.QueryEscape(token))
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err = renderProjectTpl.ExecuteTemplate(w, "share-link", struct {
+			Share share
+			Link  string
+		}{s, link})
+		if err != nil {
+			log.Printf("cannot execute template: %v", err)
+		}
+	})
+
+	http.HandleFunc("DELETE /shares", func(w http.ResponseWriter, r *http.Request) {
+		pID, err := projectID(r)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		sID, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		if _, ok := authorize(w, r, db, pID, owner); !ok {
 			return
 		}
-		if _, err := db.Exec("DELETE FROM bubbles WHERE project = ?", pID); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		found, err := revokeShare(db, pID, sID)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
 			return
 		}
-		if _, err := db.Exec("DELETE FROM projects WHERE project = ?", pID); err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		if !found {
+			http.Error(w, http.StatusText(http.StatusNotFound)+":"+errShareNotFound.Error(), http.StatusNotFound)
 			return
 		}
-		w.Header().Set("HX-Refresh", "true")
-		w.WriteHeader(http.StatusOK)
+		w.Header().Set("HX-Location", fmt.Sprintf("/projects?pID=%v", pID))
 	})
 
-	renderProjectTpl := template.Must(template.Must(baseTpl.Clone()).New("content").Parse(renderProjectTemplate))
-	http.HandleFunc("GET /projects", func(w http.ResponseWriter, r *http.Request) {
-		pID := r.URL.Query().Get("pID")
-		if err := r.ParseForm(); err != nil {
-			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+	tokensTpl := template.Must(template.Must(baseTpl.Clone()).New("content").Parse(tokensTemplate))
+	http.HandleFunc("GET /tokens", func(w http.ResponseWriter, r *http.Request) {
+		acct, ok := signedIn(w, r)
+		if !ok {
 			return
 		}
-		var deps []dep
 		dbMu.Lock()
 		defer dbMu.Unlock()
-
-		rowsPairs, err := db.Query("select left, right from pairs where project = ?", pID)
+		tokens, err := listAPITokens(db, acct.ID)
 		if err != nil {
 			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
 			return
 		}
-		defer func() {
-			if err := rowsPairs.Close(); err != nil {
-				log.Printf("cannot close rowsPairs: %v", err)
-			}
-		}()
-		input := &bytes.Buffer{}
-		knownBubblesIdx := make(map[string]struct{})
-		allKnownBubbles := make(map[string]struct{})
-		fmt.Fprintln(input, "digraph G {")
-		if !r.URL.Query().Has("vertical") {
-			fmt.Fprintln(input, `	rankdir="LR"`)
-		}
-		for rowsPairs.Next() {
-			var dep dep
-			if err := rowsPairs.Scan(&dep.Left, &dep.Right); err != nil {
-				http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
-				return
-			}
-			knownBubblesIdx[dep.Left] = struct{}{}
-			knownBubblesIdx[dep.Right] = struct{}{}
-			allKnownBubbles[dep.Left] = struct{}{}
-			allKnownBubbles[dep.Right] = struct{}{}
-			fmt.Fprintf(input, "	%q -> %q\n", dep.Left, dep.Right)
-			deps = append(deps, dep)
-		}
-		if err := rowsPairs.Err(); err != nil {
+		projects, err := listProjects(db, acct.ID)
+		if err != nil {
 			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
 			return
 		}
-		sort.Slice(deps, func(a, b int) bool {
-			if cmp := strings.Compare(deps[a].Left, deps[b].Left); cmp != 0 {
-				return cmp < 0
-			}
-			if cmp := strings.Compare(deps[a].Right, deps[b].Right); cmp != 0 {
-				return cmp < 0
-			}
-			return false
-		})
-		rowsBubbles, err := db.Query("select bubble, state from bubbles where project = ?", pID)
+		err = tokensTpl.ExecuteTemplate(w, "base", struct {
+			Tokens  []apiToken
+			Project []project
+		}{tokens, projects})
 		if err != nil {
-			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
+			log.Printf("cannot execute template: %v", err)
+		}
+	})
+
+	http.HandleFunc("POST /tokens", func(w http.ResponseWriter, r *http.Request) {
+		acct, ok := signedIn(w, r)
+		if !ok {
 			return
 		}
-		defer func() {
-			if err := rowsBubbles.Close(); err != nil {
-				log.Printf("cannot close rowsBubbles: %v", err)
-			}
-		}()
-		for rowsBubbles.Next() {
-			var bubble bubble
-			if err := rowsBubbles.Scan(&bubble.Bubble, &bubble.State); err != nil {
-				http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		var pID int64
+		if v := r.PostForm.Get("project"); v != "" {
+			var err error
+			if pID, err = strconv.ParseInt(v, 10, 64); err != nil {
+				http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
 				return
 			}
-			if _, ok := knownBubblesIdx[bubble.Bubble]; !ok {
-				continue
+		}
+		var expires time.Time
+		if v := r.PostForm.Get("expires"); v != "" {
+			day, err := time.ParseInLocation("2006-01-02", v, time.Local)
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+				return
 			}
-			delete(knownBubblesIdx, bubble.Bubble)
-			fmt.Fprintf(input, `	%q [href="/flip?pID=%v&bubble=%v",%v]`, bubble.Bubble, pID, template.URLQueryEscaper(bubble.Bubble), bubble.State.color())
-			fmt.Fprintln(input)
+			// The token is valid through the chosen day.
+			expires = day.AddDate(0, 0, 1)
 		}
-		if err := rowsBubbles.Err(); err != nil {
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		token, err := createAPIToken(db, acct.ID, pID, strings.TrimSpace(r.PostForm.Get("name")), expires)
+		if errors.Is(err, errTokenExpiry) {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
+		} else if errors.Is(err, errForbidden) {
+			http.Error(w, http.StatusText(http.StatusForbidden)+":"+err.Error(), http.StatusForbidden)
+			return
+		} else if err != nil {
 			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
 			return
 		}
-		var knownBubbles []string
-		for k := range knownBubblesIdx {
-			knownBubbles = append(knownBubbles, k)
-		}
-		sort.Strings(knownBubbles)
-		for _, bubble := range knownBubbles {
-			fmt.Fprintf(input, `	%q [href="/flip?pID=%v&bubble=%v"]`, bubble, pID, template.URLQueryEscaper(bubble))
-			fmt.Fprintln(input)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := tokensTpl.ExecuteTemplate(w, "api-token", token); err != nil {
+			log.Printf("cannot execute template: %v", err)
 		}
-		fmt.Fprintln(input, "}")
-		src := input.String()
+	})
 
-		download := r.URL.Query().Has("download")
-		if download {
-			cmd := exec.CommandContext(r.Context(), "dot", "-Tpng")
-			cmd.Stdin = input
-			var outBuf bytes.Buffer
-			cmd.Stdout = &outBuf
-			if err := cmd.Run(); err != nil {
-				log.Println(err)
-			}
-			w.Header().Set("Content-Type", "image/png")
-			w.Header().Set("Content-Disposition", `attachment; filename="graph.png"`)
-			if _, err := io.Copy(w, &outBuf); err != nil {
-				log.Println(err)
-			}
+	http.HandleFunc("DELETE /tokens", func(w http.ResponseWriter, r *http.Request) {
+		acct, ok := signedIn(w, r)
+		if !ok {
 			return
 		}
-
-		cmd := exec.CommandContext(r.Context(), "dot", "-Tsvg")
-		cmd.Stdin = input
-		var outBuf bytes.Buffer
-		cmd.Stdout = &outBuf
-		var errBuf bytes.Buffer
-		cmd.Stderr = &outBuf
-		if err := cmd.Run(); err != nil {
-			errBuf.WriteString("\n")
-			errBuf.WriteString(err.Error())
+		tID, err := strconv.ParseInt(r.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest)+":"+err.Error(), http.StatusBadRequest)
+			return
 		}
-		allKnownBubblesList := maps.Keys(allKnownBubbles)
-		slices.Sort(allKnownBubblesList)
-		var projectName string
-		rowProject := db.QueryRow("select name from projects where project = ?", pID)
-		if err := rowProject.Scan(&projectName); err != nil {
+		dbMu.Lock()
+		defer dbMu.Unlock()
+		found, err := revokeAPIToken(db, acct.ID, tID)
+		if err != nil {
 			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
 			return
 		}
-		err = renderProjectTpl.ExecuteTemplate(w, "base", graph{
-			PID:             pID,
-			Name:            projectName,
-			Input:           deps,
-			Output:          template.HTML(outBuf.String()),
-			Err:             errBuf.String(),
-			Src:             src,
-			AllKnownBubbles: allKnownBubblesList,
-			Vertical:        r.URL.Query().Has("vertical"),
-		})
-		if err != nil {
-			log.Printf("cannot execute template: %v", err)
+		if !found {
+			http.Error(w, http.StatusText(http.StatusNotFound)+":"+errTokenNotFound.Error(), http.StatusNotFound)
+			return
 		}
+		w.Header().Set("HX-Location", "/tokens")
 	})
 
 	listProjectsTpl := template.Must(template.Must(baseTpl.Clone()).New("content").Parse(listProjectsTemplate))
 	http.HandleFunc("GET /", func(w http.ResponseWriter, r *http.Request) {
+		acct, ok := signedIn(w, r)
+		if !ok {
+			return
+		}
 		dbMu.Lock()
 		defer dbMu.Unlock()
-		rows, err := db.Query("select project, name from projects", r.URL.Query().Get("left"), r.URL.Query().Get("right"))
+		projects, err := listProjects(db, acct.ID)
 		if err != nil {
 			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
 			return
 		}
-		defer func() {
-			if err := rows.Close(); err != nil {
-				log.Printf("cannot close rows: %v", err)
-			}
-		}()
-		var projects []project
-		for rows.Next() {
-			var project project
-			if err := rows.Scan(&project.ID, &project.Name); err != nil {
+		roles := make(map[uint64]role)
+		for _, p := range projects {
+			if roles[p.ID], err = roleOf(db, acct.ID, int64(p.ID)); err != nil {
 				http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
 				return
 			}
-			projects = append(projects, project)
-		}
-		if err := rows.Err(); err != nil {
-			http.Error(w, http.StatusText(http.StatusInternalServerError)+":"+err.Error(), http.StatusInternalServerError)
-			return
 		}
 		err = listProjectsTpl.ExecuteTemplate(w, "base", struct {
 			Project []project
-		}{projects})
+			Roles   map[uint64]role
+		}{projects, roles})
 		if err != nil {
 			log.Printf("cannot execute template: %v", err)
 		}
 	})
-	const bindAddr = "0.0.0.0:5466"
-	log.Println("Starting server on http://" + bindAddr)
-	check(http.ListenAndServe(bindAddr, nil))
+
+	registerAPIHandlers(http.DefaultServeMux, db, &dbMu)
+	go runWebhooks(newWebhookClient(*webhookAllowLocal), db, &dbMu)
+
+	handler := withSession(db, &dbMu, withRequestLog(http.DefaultServeMux))
+	if *tlsCert != "" {
+		infof("Starting server on https://%v", *listenAddr)
+		check(http.ListenAndServeTLS(*listenAddr, *tlsCert, *tlsKey, handler))
+	}
+	infof("Starting server on http://%v", *listenAddr)
+	check(http.ListenAndServe(*listenAddr, handler))
+}
+
+func projectID(r *http.Request) (int64, error) {
+	return strconv.ParseInt(r.URL.Query().Get("pID"), 10, 64)
+}
+
+// viewQuery carries how the graph is drawn (its direction, clusters and tag
+// filter), and the share link it was opened with, over to the next page, as
+// a query string suffix.
+func viewQuery(q url.Values) string {
+	var sb strings.Builder
+	if q.Has("vertical") {
+		sb.WriteString("&vertical")
+	}
+	if q.Has("clusters") {
+		sb.WriteString("&clusters")
+	}
+	for _, tag := range normalizeTags(q["tag"]) {
+		sb.WriteString("&tag=" + url.QueryEscape(tag))
+	}
+	if token := q.Get("share"); token != "" {
+		sb.WriteString("&share=" + url.QueryEscape(token))
+	}
+	return sb.String()
 }
 
 func check(err error) {
@@ -479,10 +1666,19 @@ const baseTemplate = `
 		<meta charset="utf-8">
 		<meta name="viewport" content="width=device-width, initial-scale=1">
 		<script src="https://cdn.jsdelivr.net/npm/htmx.org@2.0.6/dist/htmx.min.js"></script>
+		<script src="https://cdn.jsdelivr.net/npm/htmx-ext-sse@2.2.3/dist/sse.min.js"></script>
 		<link rel="stylesheet" href="https://cdn.jsdelivr.net/npm/@picocss/pico@2/css/pico.min.css">
 		<style>
 			#svg-container { text-align: center; }
 			#svg-container svg { max-width: 100%; height: auto; }
+			#gantt-container { text-align: center; }
+			#gantt-container svg { max-width: 100%; height: auto; }
+			#bubble-panel:not(:empty) {
+				position: fixed; top: 0; right: 0; z-index: 10;
+				width: min(28rem, 100%); height: 100%; overflow-y: auto;
+				padding: 1rem; background: var(--pico-background-color);
+				box-shadow: -0.25rem 0 1rem rgba(0, 0, 0, 0.2);
+			}
 			#svg-container svg a { text-decoration: none; color: black; width: 100%;  }
 		</style>
 	</head>
@@ -496,6 +1692,7 @@ const baseTemplate = `
 						</a>
 					</li>
 				</ul>
+				{{ block "nav" . }}
 				<ul>
 					<li>
 						<details class="dropdown">
@@ -513,17 +1710,84 @@ const baseTemplate = `
 							</ul>
 						</details>
 					</li>
+					<li>
+						<details class="dropdown">
+							<summary>import</summary>
+							<ul>
+								<li>
+									<form method="POST" enctype="multipart/form-data" action="/projects/import">
+										<div>
+											<label for="import-name">project name</label>
+											<input type="text" name="name" id="import-name" placeholder="taken from the file"/>
+										</div>
+										<div>
+											<label for="import-file">Graphviz file</label>
+											<input type="file" name="file" id="import-file" accept=".dot,.gv,text/vnd.graphviz" required/>
+										</div>
+										<input type="submit" value="import"/>
+									</form>
+								</li>
+							</ul>
+						</details>
+					</li>
+					<li>
+						<a href="/tokens" class="secondary">API tokens</a>
+					</li>
+					<li>
+						<a hx-post="/logout" href="#" class="secondary">sign out</a>
+					</li>
 				</ul>
+				{{ end }}
 			</nav>
 		</header>
 		<main class="container">
 		{{ template "content" . }}
 		</main>
+		<script>
+		document.body.addEventListener("htmx:responseError", function(evt) {
+			alert(evt.detail.xhr.responseText);
+		});
+		</script>
 	</body>
 </html>
 {{ end }}
 `
 
+const loginTemplate = `
+{{ define "nav" }}<ul></ul>{{ end }}
+<article style="max-width: 28rem; margin: auto">
+	<header><strong>{{ if .Signup }}Create an account{{ else }}Sign in{{ end }}</strong></header>
+	<form method="POST" enctype="application/x-www-form-urlencoded" action="{{ if .Signup }}/signup{{ else }}/login{{ end }}">
+		<input type="hidden" name="next" value="{{ .Next }}">
+		<label>user: <input type="text" name="name" autocomplete="username" required></label>
+		<label>password: <input type="password" name="password" autocomplete="{{ if .Signup }}new-password{{ else }}current-password{{ end }}" required></label>
+		<input type="submit" value="{{ if .Signup }}create account{{ else }}sign in{{ end }}"/>
+	</form>
+	<footer>
+		{{ if .Signup }}
+		<a href="/login?next={{ .Next }}" class="secondary">I already have an account</a>
+		{{ else }}
+		<a href="/signup?next={{ .Next }}" class="secondary">create an account</a>
+		{{ end }}
+	</footer>
+</article>
+`
+
+const claimTemplate = `
+<article style="max-width: 28rem; margin: auto">
+	<header><strong>Own the projects made before accounts</strong></header>
+	{{ if .Count }}
+	<p>{{ .Count }} projects have no owner yet. Claiming them makes you their owner; you can then add the other members.</p>
+	<form hx-post="/claim">
+		<label>claim token, as printed in the server log: <input type="text" name="token" value="{{ .Token }}" required></label>
+		<input type="submit" value="claim"/>
+	</form>
+	{{ else }}
+	<p>every project has an owner</p>
+	{{ end }}
+</article>
+`
+
 const listProjectsTemplate = `
 <strong>Projects</strong>
 {{ with .Project }}
@@ -531,7 +1795,12 @@ const listProjectsTemplate = `
 <ul>
 	<li>
 		<a href="/projects?pID={{.ID}}">{{.Name}}</a>
+		{{ $role := index $.Roles .ID }}
+		{{ if $role.CanManage }}
 		<a hx-delete="/projects?pID={{.ID}}" style="text-decoration: none;" hx-confirm="Are you sure you want to delete this project?">🗑️</a>
+		{{ else }}
+		<small>{{ $role }}</small>
+		{{ end }}
 	</li>
 </ul>
 {{ end }}
@@ -540,36 +1809,254 @@ const listProjectsTemplate = `
 {{ end }}
 `
 
+const tokensTemplate = `
+<strong>API tokens</strong>
+<p>
+	<small>
+		scripts can act as you by sending a token as <code>Authorization: Bearer &lt;token&gt;</code>,
+		to the API or to pages such as <code>/flip</code>
+	</small>
+</p>
+<table>
+	<thead>
+		<tr><th>name</th><th>project</th><th>created</th><th>expires</th><th>last used</th><th></th></tr>
+	</thead>
+	<tbody>
+	{{ range .Tokens }}
+	<tr>
+		<td>{{ with .Name }}{{ . }}{{ else }}unnamed token{{ end }}</td>
+		<td>{{ if .Project }}{{ .ProjectName }}{{ else }}all my projects{{ end }}</td>
+		<td>{{ .Created.Local.Format "2006-01-02 15:04" }}</td>
+		<td>{{ if .Expires.IsZero }}never{{ else }}{{ .Expires.Local.Format "2006-01-02 15:04" }}{{ end }}</td>
+		<td>{{ if .LastUsed.IsZero }}never{{ else }}{{ .LastUsed.Local.Format "2006-01-02 15:04" }}{{ end }}</td>
+		<td><a hx-delete="/tokens?id={{ .ID }}" style="text-decoration: none;" hx-confirm="Are you sure you want to revoke this token?">🗑️</a></td>
+	</tr>
+	{{ else }}
+	<tr><td colspan="6">no tokens yet</td></tr>
+	{{ end }}
+	</tbody>
+</table>
+<article>
+	<form hx-post="/tokens" hx-target="#api-token">
+		<label>name: <input type="text" name="name" placeholder="CI pipeline"></label>
+		<label>project:
+			<select name="project">
+				<option value="">all my projects</option>
+				{{ range .Project }}
+				<option value="{{ .ID }}">{{ .Name }}</option>
+				{{ end }}
+			</select>
+		</label>
+		<label>valid through: <input type="date" name="expires"></label>
+		<input type="submit" value="create token"/>
+	</form>
+	<div id="api-token"></div>
+</article>
+{{ define "api-token" }}
+<article>
+	<p>copy the token now, it will not be shown again:</p>
+	<input type="text" value="{{ . }}" readonly onclick="this.select()">
+	<small>reload the page to see it in the list</small>
+</article>
+{{ end }}
+`
+
+const boardTemplate = `
+<strong>Project: {{ .Name }}</strong>
+<section>
+	<a href="/projects?pID={{ .PID }}" class="secondary">graph</a>
+</section>
+<section hx-ext="sse" sse-connect="/projects/events?pID={{ .PID }}">
+	<div id="board" class="grid" hx-get="/board?pID={{ .PID }}" hx-trigger="sse:changed" hx-select="#board" hx-swap="outerHTML">
+		{{ range .Columns }}
+		{{ if $.Role.CanEdit }}
+		<div ondragover="event.preventDefault()" ondrop="dropCard(event, {{ .State }})">
+		{{ else }}
+		<div>
+		{{ end }}
+			<h6>{{ .State }}</h6>
+			{{ range .Cards }}
+			{{ if $.Role.CanEdit }}
+			<article draggable="true" ondragstart="dragCard(event, {{ .Bubble }})" style="cursor: grab">
+			{{ else }}
+			<article>
+			{{ end }}
+				<strong>{{ .Bubble }}</strong>
+				{{ with .Duration }}<small>({{ . }})</small>{{ end }}
+				{{ with .Blockers }}
+				<br><mark title="waiting for {{ range $i, $b := . }}{{ if $i }}, {{ end }}{{ $b }}{{ end }}">blocked</mark>
+				{{ else }}{{ if .Ready }}
+				<br><ins>ready</ins>
+				{{ end }}{{ end }}
+			</article>
+			{{ end }}
+		</div>
+		{{ end }}
+	</div>
+</section>
+<script>
+function dragCard(ev, bubble) {
+	ev.dataTransfer.setData("text/plain", bubble)
+}
+async function dropCard(ev, state) {
+	ev.preventDefault()
+	const bubble = ev.dataTransfer.getData("text/plain")
+	const response = await fetch("/state?pID={{ .PID }}&bubble=" + encodeURIComponent(bubble), {
+		method: "POST",
+		body: new URLSearchParams({state: state}),
+	})
+	if (!response.ok) {
+		alert(await response.text())
+		return
+	}
+	htmx.ajax("GET", "/board?pID={{ .PID }}", {target: "#board", select: "#board", swap: "outerHTML"})
+}
+</script>
+`
+
 const renderProjectTemplate = `
 {{- $pid := .PID -}}
 <strong>Project: {{ .Name }}</strong>
 <section>
 <div class="grid">
 	<div>
-		<a href="/projects?pID={{ .PID }}&download{{ if .Vertical }}&vertical{{end}}" class="secondary">download</a>
+		<details class="dropdown" style="display: inline-block; margin-bottom: 0">
+			<summary class="secondary">download</summary>
+			<ul>
+				{{ range .Exports }}
+				<li><a href="/projects?pID={{ $pid }}&download&format={{ .Name }}{{ $.View }}" hx-boost="false">{{ .Label }}</a></li>
+				{{ end }}
+			</ul>
+		</details>
 		<a href="javascript: copyImageToClipboard()" class="secondary">copy</a>
-		{{ if .Vertical }}
-		<a href="/projects?pID={{ .PID }}" class="secondary">horizontal</a>
-		{{ else }}
-		<a href="/projects?pID={{ .PID }}&vertical" class="secondary">vertical</a>
+		<span id="undo-redo">
+		{{ if .Role.CanEdit }}
+		{{ with .UndoLabel }}
+		<a hx-post="/undo?pID={{ $pid }}{{ $.View }}" href="#" class="secondary" title="{{ . }}">undo</a>
+		{{ end }}
+		{{ with .RedoLabel }}
+		<a hx-post="/redo?pID={{ $pid }}{{ $.View }}" href="#" class="secondary" title="{{ . }}">redo</a>
+		{{ end }}
+		{{ end }}
+		</span>
+		{{ if not .Share }}
+		<a href="/board?pID={{ .PID }}" class="secondary">board</a>
+		{{ end }}
+		<a href="/projects?pID={{ .PID }}{{ .Rotate }}" class="secondary">{{ if .Vertical }}horizontal{{ else }}vertical{{ end }}</a>
+		{{ with .AllTags }}
+		<details class="dropdown" style="display: inline-block; margin-bottom: 0">
+			<summary class="secondary">tags{{ with $.Tags }}: {{ range $i, $t := . }}{{ if $i }}, {{ end }}{{ $t }}{{ end }}{{ end }}</summary>
+			<ul>
+				<li>
+					<form method="GET" action="/projects" style="margin-bottom: 0">
+						<input type="hidden" name="pID" value="{{ $pid }}">
+						{{ with $.Share }}<input type="hidden" name="share" value="{{ . }}">{{ end }}
+						{{ if $.Vertical }}<input type="hidden" name="vertical" value="">{{ end }}
+						<label><input type="checkbox" name="clusters" value=""{{ if $.Clusters }} checked{{ end }}> group by tag</label>
+						<hr>
+						<small>show only, with their neighbors:</small>
+						{{ range . }}
+						<label><input type="checkbox" name="tag" value="{{ . }}"{{ if index $.SelectedTags . }} checked{{ end }}> {{ . }}</label>
+						{{ end }}
+						<input type="submit" value="apply">
+					</form>
+				</li>
+			</ul>
+		</details>
 		{{ end }}
 	</div>
 </div>
 </section>
-<section>
+<aside id="bubble-panel"></aside>
+<section hx-ext="sse" sse-connect="/projects/events?pID={{ .PID }}{{ .View }}">
 	<div class="grid">
-		<div id="svg-container">
+		<div id="svg-container" hx-get="/projects?pID={{ .PID }}{{ .View }}{{ with .Base }}&diff={{ .ID }}{{ end }}" hx-trigger="sse:changed" hx-select="#svg-container" hx-select-oob="#pairsTableBody,#gantt-container,#ready-list,#critical-path,#cycles,#undo-redo,#historyTableBody" hx-swap="outerHTML">
 			{{ .Output }}
 		</div>
 	</div>
+	<small>right-click a bubble to see{{ if .Role.CanEdit }} and edit{{ end }} its details</small>
+	<div class="grid">
+		<div id="gantt-container">
+			{{ .Gantt }}
+		</div>
+	</div>
+	{{ with .Base }}
+	<div class="grid">
+		<article>
+			comparing with snapshot <strong>{{ .Name }}</strong> taken on {{ .At.Local.Format "2006-01-02 15:04" }}:
+			<a href="/projects?pID={{ $pid }}{{ $.View }}" class="secondary">back to the current graph</a>
+			{{ if $.Changes.Empty }}
+			<p>no changes since then</p>
+			{{ else }}
+			{{ template "changes" $.Changes }}
+			{{ end }}
+		</article>
+	</div>
+	{{ end }}
+	{{ with .Blocked }}
+	<div class="grid">
+		<article>
+			<strong>{{ .Bubble }}</strong> cannot be {{ .State }} yet, it is waiting for:
+			<ul>
+			{{ range .Blockers }}
+				<li>{{ . }}</li>
+			{{ end }}
+			</ul>
+		</article>
+	</div>
+	{{ end }}
+	<div class="grid">
+		<article id="ready-list">
+			<strong>ready to start</strong>
+			{{ with .Ready }}
+			<ul>
+			{{ range . }}
+				{{ if $.Role.CanEdit }}
+				<li><a href="#" hx-post="/flip?pID={{ $pid }}&bubble={{ .Bubble | urlquery }}{{ $.View }}">{{ .Bubble }}</a></li>
+				{{ else }}
+				<li>{{ .Bubble }}</li>
+				{{ end }}
+			{{ end }}
+			</ul>
+			{{ else }}
+			<p>nothing can be started right now</p>
+			{{ end }}
+		</article>
+	</div>
+	<div id="critical-path">
+	{{ with .CriticalPath }}
+	<div class="grid">
+		<article>
+			<strong>critical path</strong>
+			<p>{{ range $i, $b := . }}{{ if $i }} → {{ end }}{{ $b }}{{ end }}</p>
+			<p>{{ $.CriticalRemaining }} left on the critical path, {{ $.RemainingWork }} of work left in total</p>
+		</article>
+	</div>
+	{{ end }}
+	</div>
+	<div id="cycles">
+	{{ with .Cycles }}
+	<div class="grid">
+		<article>
+			<strong>cycles detected</strong>
+			<ul>
+			{{ range . }}
+				<li>{{ range $i, $b := . }}{{ if $i }}, {{ end }}{{ $b }}{{ end }}</li>
+			{{ end }}
+			</ul>
+		</article>
+	</div>
+	{{ end }}
+	</div>
 </section>
 <section>
 <div class="grid">
+	{{ if .Role.CanEdit }}
 	<div>
 		<article>
 			<details>
 				<summary>rename</summary>
-				<form method="POST" enctype="application/x-www-form-urlencoded" action="/rename?pID={{ .PID }}{{ if .Vertical }}&vertical{{ end }}">
+				<form method="POST" enctype="application/x-www-form-urlencoded" action="/rename?pID={{ .PID }}{{ .View }}">
 					<label>from: <input type="text" list="knownBubbles" name="from"></label>
 					<label>to: <input type="text" name="to"></label>
 					<input type="submit" value="rename"/>
@@ -581,13 +2068,216 @@ const renderProjectTemplate = `
 		<article>
 			<details>
 				<summary>delete</summary>
-				<form method="POST" enctype="application/x-www-form-urlencoded" action="/delete?pID={{ .PID }}{{ if .Vertical }}&vertical{{ end }}">
+				<form method="POST" enctype="application/x-www-form-urlencoded" action="/delete?pID={{ .PID }}{{ .View }}">
 					<label>activity: <input type="text" list="knownBubbles" name="activity"></label>
 					<input type="submit" value="delete"/>
 				</form>
 			</details>
 		</article>
 	</div>
+	{{ end }}
+	<div>
+		<article>
+			<details>
+				<summary>states</summary>
+				<table>
+					<tbody>
+					{{ $states := .States }}
+					{{ range .Bubbles }}
+					{{ $state := .State }}
+					<tr>
+						<td><a href="#" hx-get="/bubble?pID={{ $pid }}&bubble={{ .Bubble | urlquery }}{{ $.View }}" hx-target="#bubble-panel">{{ .Bubble }}</a></td>
+						{{ if $.Role.CanEdit }}
+						<td>
+							<select name="state" hx-post="/state?pID={{ $pid }}&bubble={{ .Bubble | urlquery }}{{ $.View }}" hx-trigger="change">
+							{{ range $states }}
+								<option value="{{ . }}" {{ if eq . $state }}selected{{ end }}>{{ . }}</option>
+							{{ end }}
+							</select>
+						</td>
+						<td>
+							<input type="number" name="duration" min="0" max="3650" step="any" placeholder="duration" value="{{ with index $.Durations .Bubble }}{{ . }}{{ end }}" hx-post="/duration?pID={{ $pid }}&bubble={{ .Bubble | urlquery }}{{ $.View }}" hx-trigger="change">
+						</td>
+						{{ else }}
+						<td>{{ $state }}</td>
+						<td>{{ with index $.Durations .Bubble }}{{ . }}{{ end }}</td>
+						{{ end }}
+					</tr>
+					{{ end }}
+					</tbody>
+				</table>
+			</details>
+		</article>
+	</div>
+	<div>
+		<article>
+			<details>
+				<summary>snapshots</summary>
+				{{ if .Role.CanEdit }}
+				<form method="POST" enctype="application/x-www-form-urlencoded" action="/snapshots?pID={{ .PID }}{{ .View }}">
+					<label>name: <input type="text" name="name" placeholder="sprint 12 plan"></label>
+					<input type="submit" value="take snapshot"/>
+				</form>
+				{{ end }}
+				<ul>
+				{{ range .Snapshots }}
+					<li>
+						<a href="/projects?pID={{ $pid }}&diff={{ .ID }}{{ $.View }}">{{ .Name }}</a>
+						<small>{{ .At.Local.Format "2006-01-02 15:04" }}</small>
+						{{ if $.Role.CanEdit }}
+						<a hx-delete="/snapshots?pID={{ $pid }}&snapshot={{ .ID }}{{ $.View }}" style="text-decoration: none;" hx-confirm="Are you sure you want to delete this snapshot?">🗑️</a>
+						{{ end }}
+					</li>
+				{{ end }}
+				</ul>
+			</details>
+		</article>
+	</div>
+	{{ if .Role.CanEdit }}
+	<div>
+		<article>
+			<details>
+				<summary>CSV import</summary>
+				<form method="POST" enctype="multipart/form-data" action="/csv?pID={{ .PID }}{{ .View }}">
+					<label>pairs (left,right): <input type="file" name="pairs" accept=".csv,text/csv"></label>
+					<label>bubbles (bubble,state): <input type="file" name="bubbles" accept=".csv,text/csv"></label>
+					<fieldset>
+						<label><input type="radio" name="mode" value="merge" checked> merge into the project</label>
+						<label><input type="radio" name="mode" value="replace"> replace the project's pairs and states with the uploaded ones</label>
+					</fieldset>
+					<div id="csv-preview"></div>
+					<input type="submit" value="import"/>
+					<button type="button" class="secondary" hx-post="/csv?pID={{ .PID }}&preview" hx-encoding="multipart/form-data" hx-target="#csv-preview">preview</button>
+				</form>
+			</details>
+		</article>
+	</div>
+	<div>
+		<article>
+			<details>
+				<summary>settings</summary>
+				<form method="POST" enctype="application/x-www-form-urlencoded" action="/settings?pID={{ .PID }}{{ .View }}">
+					<label><input type="checkbox" name="abortedUnblocks" {{ if .AbortedUnblocks }}checked{{ end }}> aborted bubbles unblock their successors</label>
+					<label><input type="checkbox" name="strict" {{ if .Strict }}checked{{ end }}> strict mode: bubbles cannot start before their predecessors are done</label>
+					<label>start date: <input type="date" name="start" value="{{ .Start }}"></label>
+					<input type="submit" value="save"/>
+				</form>
+			</details>
+		</article>
+	</div>
+	{{ end }}
+	{{ if not .Share }}
+	<div>
+		<article>
+			<details>
+				<summary>members</summary>
+				<table>
+					<tbody>
+					{{ range .Members }}
+					<tr>
+						<td>{{ .Name }}</td>
+						{{ if $.Role.CanManage }}
+						<td>
+							<select name="role" hx-post="/members?pID={{ $pid }}&user={{ .ID }}" hx-trigger="change">
+							{{ $role := .Role }}
+							{{ range $.Roles }}
+								<option value="{{ . }}" {{ if eq . $role }}selected{{ end }}>{{ . }}</option>
+							{{ end }}
+							</select>
+						</td>
+						<td><a hx-delete="/members?pID={{ $pid }}&user={{ .ID }}" style="text-decoration: none;" hx-confirm="Are you sure you want to remove {{ .Name }} from the project?">🗑️</a></td>
+						{{ else }}
+						<td>{{ .Role }}</td>
+						{{ end }}
+					</tr>
+					{{ end }}
+					</tbody>
+				</table>
+				{{ if .Role.CanManage }}
+				<form method="POST" enctype="application/x-www-form-urlencoded" action="/members?pID={{ .PID }}">
+					<label>user: <input type="text" name="name"></label>
+					<label>role:
+						<select name="role">
+						{{ range .Roles }}
+							<option value="{{ . }}">{{ . }}</option>
+						{{ end }}
+						</select>
+					</label>
+					<input type="submit" value="add"/>
+				</form>
+				{{ end }}
+			</details>
+		</article>
+	</div>
+	{{ end }}
+	{{ if and .Role.CanManage (not .Share) }}
+	<div>
+		<article>
+			<details>
+				<summary>webhooks</summary>
+				<p><small>each event is posted as JSON, signed with the secret in the <code>X-Bubbles-Signature: sha256=&lt;HMAC of the body&gt;</code> header</small></p>
+				<ul>
+				{{ range .Webhooks }}
+					<li>
+						<code>{{ .URL }}</code>
+						<a hx-delete="/webhooks?pID={{ $pid }}&id={{ .ID }}" style="text-decoration: none;" hx-confirm="Are you sure you want to delete this webhook?">🗑️</a>
+						<br><small>{{ range $i, $k := .Kinds }}{{ if $i }}, {{ end }}{{ $k }}{{ end }}</small>
+						<br><small>secret: <code>{{ .Secret }}</code></small>
+					</li>
+				{{ end }}
+				</ul>
+				<form method="POST" enctype="application/x-www-form-urlencoded" action="/webhooks?pID={{ .PID }}">
+					<label>url: <input type="url" name="url" placeholder="https://" required></label>
+					<fieldset>
+					{{ range .WebhookKinds }}
+						<label><input type="checkbox" name="event" value="{{ . }}" checked> {{ . }}</label>
+					{{ end }}
+					</fieldset>
+					<input type="submit" value="add webhook"/>
+				</form>
+				{{ with .Deliveries }}
+				<strong>deliveries</strong>
+				<table>
+					<tbody>
+					{{ range . }}
+					<tr>
+						<td>{{ .Created.Local.Format "2006-01-02 15:04:05" }}</td>
+						<td>{{ .Kind }}</td>
+						<td><small>{{ .URL }}</small></td>
+						<td>{{ .State }}{{ with .Status }} ({{ . }}){{ end }}</td>
+						<td><small>{{ .Attempts }} attempts{{ if not .NextAttempt.IsZero }}, next at {{ .NextAttempt.Local.Format "15:04:05" }}{{ end }}</small></td>
+						<td><small>{{ .Error }}</small></td>
+					</tr>
+					{{ end }}
+					</tbody>
+				</table>
+				{{ end }}
+			</details>
+		</article>
+	</div>
+	<div>
+		<article>
+			<details>
+				<summary>sharing</summary>
+				<p><small>share links show the graph, read-only, to anyone who has them</small></p>
+				<ul>
+				{{ range .Shares }}
+					<li>
+						{{ with .Name }}{{ . }}{{ else }}unnamed link{{ end }}
+						<small>{{ .Created.Local.Format "2006-01-02 15:04" }}</small>
+						<a hx-delete="/shares?pID={{ $pid }}&id={{ .ID }}" style="text-decoration: none;" hx-confirm="Are you sure you want to revoke this share link?">🗑️</a>
+					</li>
+				{{ end }}
+				</ul>
+				<form hx-post="/shares?pID={{ .PID }}" hx-target="#share-link">
+					<label>name: <input type="text" name="name" placeholder="for the steering committee"></label>
+					<input type="submit" value="create share link"/>
+				</form>
+				<div id="share-link"></div>
+			</details>
+		</article>
+	</div>
+	{{ end }}
 	<div>
 		<article>
 			<details>
@@ -599,6 +2289,30 @@ const renderProjectTemplate = `
 		</article>
 	</div>
 </div>
+{{ if not .Share }}
+<div class="grid">
+	<div>
+		<article>
+			<details>
+				<summary>history</summary>
+				<table>
+					<tbody id="historyTableBody">
+					{{ range .History }}
+					<tr>
+						<td>{{ .At.Local.Format "2006-01-02 15:04:05" }}</td>
+						<td>{{ .Actor }}</td>
+						<td>{{ . }}</td>
+					</tr>
+					{{ else }}
+					<tr><td>no changes recorded yet</td></tr>
+					{{ end }}
+					</tbody>
+				</table>
+			</details>
+		</article>
+	</div>
+</div>
+{{ end }}
 <div class="grid">
 	<div>
 		<hr/>
@@ -614,7 +2328,8 @@ const renderProjectTemplate = `
 		{{ if .Err }}
 			<div>{{ .Err }}</div>
 		{{ end }}
-		<form method="POST" enctype="application/x-www-form-urlencoded" action="/store?pID={{ .PID }}{{ if .Vertical }}&vertical{{ end }}">
+		{{ if .Role.CanEdit }}
+		<form method="POST" enctype="application/x-www-form-urlencoded" action="/store?pID={{ .PID }}{{ .View }}">
 			<fieldset class="grid">
 				<input type="text" list="knownBubbles" id="newLeft" name="newLeft" onKeyUp="javascript: filter()">
 				<input type="text" list="knownBubbles" id="newCenter" name="newCenter" onKeyUp="javascript: filter()">
@@ -622,18 +2337,26 @@ const renderProjectTemplate = `
 				<input type="submit" value="➕" class="outline contrast"/>
 			</fieldset>
 		</form>
+		<form method="POST" enctype="application/x-www-form-urlencoded" action="/dsl?pID={{ .PID }}{{ .View }}">
+			<textarea name="dsl" rows="4" placeholder="design -> build -> {test docs} -> release&#10;design [done]"></textarea>
+			<div id="dsl-errors"></div>
+			<input type="submit" value="add pairs" class="outline contrast"/>
+		</form>
+		{{ end }}
 	</div>
 </div>
 <div class="grid">
 	<div>
 		<table>
 			<tbody id="pairsTableBody" class="striped">
-			{{ $vertical := .Vertical }}
+			{{ $view := .View }}
 			{{ range .Input }}
 			<tr id="pair-{{ .Left }}-{{ .Right }}-{{ $pid }}" data-left="{{ .Left }}" data-right="{{ .Right }}">
 				<td>{{ .Left }}</td>
 				<td>{{ .Right }}</td>
-				<td><button hx-delete="/remove?pID={{ $pid }}&left={{.Left}}&right={{.Right}}{{ if $vertical }}&vertical{{ end }}" class="outline contrast">🗑️</button></td>
+				{{ if $.Role.CanEdit }}
+				<td><button hx-delete="/remove?pID={{ $pid }}&left={{.Left}}&right={{.Right}}{{ $view }}" class="outline contrast">🗑️</button></td>
+				{{ end }}
 			</tr>
 			{{ end }}
 			</tbody>
@@ -696,9 +2419,33 @@ function filter() {
 		}
 	}
 }
+// Bubbles link to /flip, which changes the project, so it takes a POST
+// rather than the GET of following a link.
+document.getElementById("svg-container").closest("section").addEventListener("click", function(evt) {
+	const link = evt.target.closest("#svg-container a")
+	if (!link) {
+		return
+	}
+	const href = link.getAttribute("href") || link.getAttributeNS("http://www.w3.org/1999/xlink", "href")
+	if (!href || !href.startsWith("/flip?")) {
+		return
+	}
+	evt.preventDefault()
+	evt.stopPropagation()
+	htmx.ajax("POST", href + "{{ .View }}", {source: link})
+}, true)
+document.getElementById("svg-container").closest("section").addEventListener("contextmenu", function(evt) {
+	const node = evt.target.closest("#svg-container g.node")
+	if (!node) {
+		return
+	}
+	evt.preventDefault()
+	const bubble = node.querySelector("title").textContent
+	htmx.ajax("GET", "/bubble?pID={{ .PID }}{{ .View }}&bubble=" + encodeURIComponent(bubble), {target: "#bubble-panel", swap: "innerHTML"})
+})
 async function copyImageToClipboard() {
 	try {
-		const response = await fetch("/projects?pID={{ .PID }}&download{{ if .Vertical }}&vertical{{end}}");
+		const response = await fetch("/projects?pID={{ .PID }}&download{{ .View }}");
 		const blob = await response.blob();
 		await navigator.clipboard.write([
 			new ClipboardItem({[blob.type]: blob})
@@ -708,4 +2455,72 @@ async function copyImageToClipboard() {
 	}
 }
 </script>
+{{ define "changes" }}
+<ul>
+{{ range .AddedPairs }}
+	<li>added {{ .Left }} -&gt; {{ .Right }}</li>
+{{ end }}
+{{ range .RemovedPairs }}
+	<li>removed {{ .Left }} -&gt; {{ .Right }}</li>
+{{ end }}
+{{ range .AddedBubbles }}
+	<li>new bubble {{ . }}</li>
+{{ end }}
+{{ range .RemovedBubbles }}
+	<li>gone bubble {{ . }}</li>
+{{ end }}
+{{ range .ChangedStates }}
+	<li>{{ .Bubble }}: {{ .Old }} -&gt; {{ .New }}</li>
+{{ end }}
+</ul>
+{{ end }}
+{{ define "bubble-panel" }}
+<article>
+	<header>
+		<a href="#" onclick="document.getElementById('bubble-panel').innerHTML = ''; return false" style="float: right; text-decoration: none">✖</a>
+		<strong>{{ .Bubble }}</strong>
+	</header>
+	{{ with .Info.Description }}{{ $.Info.DescriptionHTML }}{{ end }}
+	{{ with .Info.URL }}<p><a href="{{ . }}" target="_blank" rel="noopener">{{ . }}</a></p>{{ end }}
+	{{ if .Role.CanEdit }}
+	<form method="POST" enctype="application/x-www-form-urlencoded" action="/bubble?pID={{ .PID }}&bubble={{ .Bubble }}{{ .View }}">
+		<label>description (Markdown): <textarea name="description" rows="6">{{ .Info.Description }}</textarea></label>
+		<label>assignee: <input type="text" name="assignee" value="{{ .Info.Assignee }}"></label>
+		<label>due date: <input type="date" name="due" value="{{ .Info.Due }}"></label>
+		<label>link: <input type="url" name="url" value="{{ .Info.URL }}" placeholder="https://"></label>
+		<label>tags: <input type="text" name="tags" value="{{ range $i, $t := .Info.Tags }}{{ if $i }}, {{ end }}{{ $t }}{{ end }}" placeholder="team, component, milestone"></label>
+		<input type="submit" value="save"/>
+	</form>
+	{{ else }}
+	{{ with .Info.Assignee }}<p>assignee: {{ . }}</p>{{ end }}
+	{{ with .Info.Due }}<p>due: {{ . }}</p>{{ end }}
+	{{ with .Info.Tags }}<p>tags: {{ range $i, $t := . }}{{ if $i }}, {{ end }}{{ $t }}{{ end }}</p>{{ end }}
+	{{ end }}
+</article>
+{{ end }}
+{{ define "share-link" }}
+<article>
+	<p>anyone with this link can see the project; it will not be shown again:</p>
+	<input type="text" value="{{ .Link }}" readonly onclick="this.select()">
+	<small>reload the page to see it in the list</small>
+</article>
+{{ end }}
+{{ define "dsl-errors" }}
+<ul>
+{{ range . }}
+	<li><small>{{ . }}</small></li>
+{{ end }}
+</ul>
+{{ end }}
+{{ define "csv-preview" }}
+<article>
+	<strong>dry run</strong>
+	{{ if .Empty }}
+	<p>the import would change nothing</p>
+	{{ else }}
+	<p>the import would make these changes:</p>
+	{{ template "changes" . }}
+	{{ end }}
+</article>
+{{ end }}
 `
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+var errUnsupportedFormat = errors.New("unsupported format")
+
+// renderer turns Graphviz source into an image in the given format, such as
+// "svg" or "png".
+type renderer interface {
+	render(ctx context.Context, src, format string) ([]byte, error)
+}
+
+// newRenderer returns the renderer with the given name: "graphviz" runs the
+// dot command found at dotPath, "builtin" lays the graph out in-process and
+// needs no external tools, but can only produce SVG.
+func newRenderer(name, dotPath string) (renderer, error) {
+	switch name {
+	case "graphviz":
+		return graphvizRenderer{path: dotPath}, nil
+	case "builtin":
+		return builtinRenderer{}, nil
+	}
+	return nil, fmt.Errorf("unknown renderer %q", name)
+}
+
+type graphvizRenderer struct {
+	path string
+}
+
+func (g graphvizRenderer) render(ctx context.Context, src, format string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, g.path, "-T"+format)
+	cmd.Stdin = strings.NewReader(src)
+	var outBuf, errBuf bytes.Buffer
+	cmd.Stdout = &outBuf
+	cmd.Stderr = &errBuf
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(errBuf.String()); msg != "" {
+			return nil, fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, err
+	}
+	return outBuf.Bytes(), nil
+}
+
+type builtinRenderer struct{}
+
+func (builtinRenderer) render(_ context.Context, src, format string) ([]byte, error) {
+	if format != "svg" {
+		return nil, fmt.Errorf("%w: %s", errUnsupportedFormat, format)
+	}
+	g, err := parseDOT(src)
+	if err != nil {
+		return nil, err
+	}
+	return renderSVG(layoutGraph(g)), nil
+}
+
+// cachedRenderer remembers the most recently used renders of another
+// renderer. Entries are keyed by a hash of the source and format, so a
+// change to the graph simply misses the cache and old entries age out.
+type cachedRenderer struct {
+	next renderer
+	size int
+
+	mu      sync.Mutex
+	entries map[[sha256.Size]byte]*list.Element
+	lru     *list.List // of *renderCacheEntry, most recently used first
+}
+
+type renderCacheEntry struct {
+	key [sha256.Size]byte
+	out []byte
+}
+
+// newCachedRenderer wraps next with a cache of up to size renders. A size of
+// zero or less disables the cache.
+func newCachedRenderer(next renderer, size int) renderer {
+	if size <= 0 {
+		return next
+	}
+	return &cachedRenderer{
+		next:    next,
+		size:    size,
+		entries: make(map[[sha256.Size]byte]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+func (c *cachedRenderer) render(ctx context.Context, src, format string) ([]byte, error) {
+	key := sha256.Sum256([]byte(format + "\x00" + src))
+	c.mu.Lock()
+	if elem, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(elem)
+		out := elem.Value.(*renderCacheEntry).out
+		c.mu.Unlock()
+		return out, nil
+	}
+	c.mu.Unlock()
+
+	out, err := c.next.render(ctx, src, format)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok {
+		c.entries[key] = c.lru.PushFront(&renderCacheEntry{key: key, out: out})
+		for c.lru.Len() > c.size {
+			oldest := c.lru.Back()
+			c.lru.Remove(oldest)
+			delete(c.entries, oldest.Value.(*renderCacheEntry).key)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// countingRenderer echoes the format and source, and counts its calls.
+type countingRenderer struct {
+	calls map[string]int
+	err   error
+}
+
+func (c *countingRenderer) render(_ context.Context, src, format string) ([]byte, error) {
+	c.calls[format+" "+src]++
+	if c.err != nil {
+		return nil, c.err
+	}
+	return []byte(format + " " + src), nil
+}
+
+func TestCachedRenderer(t *testing.T) {
+	type call struct {
+		src, format string
+		rendered    bool
+	}
+	tests := []struct {
+		name  string
+		size  int
+		calls []call
+	}{
+		{"hit", 2, []call{{"a", "svg", true}, {"a", "svg", false}}},
+		{"format is part of the key", 2, []call{{"a", "svg", true}, {"a", "png", true}, {"a", "svg", false}}},
+		{"least recently used is evicted", 2, []call{
+			{"a", "svg", true},
+			{"b", "svg", true},
+			{"a", "svg", false},
+			{"c", "svg", true}, // evicts b
+			{"a", "svg", false},
+			{"b", "svg", true}, // evicts c
+			{"c", "svg", true},
+		}},
+		{"disabled", 0, []call{{"a", "svg", true}, {"a", "svg", true}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := &countingRenderer{calls: make(map[string]int)}
+			r := newCachedRenderer(next, tt.size)
+			for i, c := range tt.calls {
+				key := c.format + " " + c.src
+				before := next.calls[key]
+				out, err := r.render(context.Background(), c.src, c.format)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(out) != key {
+					t.Errorf("call %v: got %q, want %q", i, out, key)
+				}
+				if rendered := next.calls[key] > before; rendered != c.rendered {
+					t.Errorf("call %v (%v): rendered = %v, want %v", i, key, rendered, c.rendered)
+				}
+			}
+		})
+	}
+}
+
+func TestCachedRendererErrors(t *testing.T) {
+	next := &countingRenderer{calls: make(map[string]int), err: errors.New("dot crashed")}
+	r := newCachedRenderer(next, 2)
+	for range 2 {
+		if _, err := r.render(context.Background(), "a", "svg"); !errors.Is(err, next.err) {
+			t.Fatalf("render = %v, want %v", err, next.err)
+		}
+	}
+	if n := next.calls["svg a"]; n != 2 {
+		t.Errorf("rendered %v times, want failures not to be cached", n)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// share is a link that opens a read-only view of a project to anyone who
+// has it, without signing in. Only a hash of its token is kept, so the link
+// can be seen once, when it is made.
+type share struct {
+	ID      int64     `json:"id"`
+	Project int64     `json:"project"`
+	Name    string    `json:"name"`
+	Created time.Time `json:"created"`
+}
+
+var errShareNotFound = errors.New("this share link does not exist or was revoked")
+
+// createShare returns the new share and the token that opens it.
+func createShare(q queryer, pID int64, name string) (share, string, error) {
+	token, err := newToken()
+	if err != nil {
+		return share{}, "", err
+	}
+	s := share{Project: pID, Name: name, Created: time.Now().UTC()}
+	result, err := q.Exec("insert into shares (project, name, token, created) values (?, ?, ?, ?)", pID, name, hashToken(token), s.Created)
+	if err != nil {
+		return share{}, "", err
+	}
+	s.ID, err = result.LastInsertId()
+	return s, token, err
+}
+
+// listShares returns the project's share links, newest first.
+func listShares(q queryer, pID int64) ([]share, error) {
+	rows, err := q.Query("select id, project, name, created from shares where project = ? order by id desc", pID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	shares := []share{}
+	for rows.Next() {
+		var s share
+		if err := rows.Scan(&s.ID, &s.Project, &s.Name, &s.Created); err != nil {
+			return nil, err
+		}
+		shares = append(shares, s)
+	}
+	return shares, rows.Err()
+}
+
+// revokeShare reports whether the share existed.
+func revokeShare(q queryer, pID, sID int64) (bool, error) {
+	result, err := q.Exec("delete from shares where project = ? and id = ?", pID, sID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// checkShare returns the role a share link gives in the project, or
+// errShareNotFound when the token does not open it.
+func checkShare(q queryer, pID int64, token string) (role, error) {
+	var ok bool
+	err := q.QueryRow("select count(*) > 0 from shares where project = ? and token = ?", pID, hashToken(token)).Scan(&ok)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", errShareNotFound
+	}
+	return viewer, nil
+}
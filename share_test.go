@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestShares(t *testing.T) {
+	db := newTestDB(t)
+	pID := newTestProject(t, db)
+	other := newTestProject(t, db)
+	board, boardToken, err := createShare(db, pID, "board")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, clientToken, err := createShare(db, pID, "client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if boardToken == clientToken {
+		t.Fatalf("two shares got the same token %q", boardToken)
+	}
+	var stored int
+	if err := db.QueryRow("select count(*) from shares where token in (?, ?)", boardToken, clientToken).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored != 0 {
+		t.Errorf("%v share tokens are stored as they are, want only their hashes", stored)
+	}
+
+	shares, err := listShares(db, pID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shares) != 2 || shares[0].ID != client.ID || shares[1].ID != board.ID || shares[1].Name != "board" {
+		t.Errorf("listShares = %+v, want client then board", shares)
+	}
+	if shares, err := listShares(db, other); err != nil || len(shares) != 0 {
+		t.Errorf("listShares of another project = %+v, %v", shares, err)
+	}
+
+	checks := []struct {
+		name  string
+		pID   int64
+		token string
+		err   error
+	}{
+		{"board", pID, boardToken, nil},
+		{"client", pID, clientToken, nil},
+		{"another project", other, boardToken, errShareNotFound},
+		{"unknown token", pID, "nope", errShareNotFound},
+		{"no token", pID, "", errShareNotFound},
+	}
+	for _, tt := range checks {
+		have, err := checkShare(db, tt.pID, tt.token)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%v: checkShare = %v, want %v", tt.name, err, tt.err)
+		} else if err == nil && have != viewer {
+			t.Errorf("%v: checkShare gives %q, want %q", tt.name, have, viewer)
+		}
+	}
+
+	if ok, err := revokeShare(db, other, board.ID); err != nil || ok {
+		t.Errorf("revoking from another project = %v, %v, want false", ok, err)
+	}
+	if ok, err := revokeShare(db, pID, board.ID); err != nil || !ok {
+		t.Fatalf("revokeShare = %v, %v, want true", ok, err)
+	}
+	if ok, err := revokeShare(db, pID, board.ID); err != nil || ok {
+		t.Errorf("revoking twice = %v, %v, want false", ok, err)
+	}
+	if _, err := checkShare(db, pID, boardToken); !errors.Is(err, errShareNotFound) {
+		t.Errorf("revoked link: checkShare = %v, want %v", err, errShareNotFound)
+	}
+	if have, err := checkShare(db, pID, clientToken); err != nil || have != viewer {
+		t.Errorf("the other link: checkShare = %q, %v, want %q", have, err, viewer)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"slices"
+	"sort"
+	"time"
+)
+
+// snapshot is a named, frozen copy of a project's state.
+type snapshot struct {
+	ID      int64         `json:"id"`
+	Project int64         `json:"project"`
+	Name    string        `json:"name"`
+	At      time.Time     `json:"at"`
+	State   *projectState `json:"state,omitempty"`
+}
+
+type stateChange struct {
+	Bubble string      `json:"bubble"`
+	Old    bubbleState `json:"old"`
+	New    bubbleState `json:"new"`
+}
+
+// projectDiff is what changed from one state of a project to another. State
+// changes are reported only for bubbles present in both states.
+type projectDiff struct {
+	AddedPairs     []dep         `json:"added_pairs"`
+	RemovedPairs   []dep         `json:"removed_pairs"`
+	AddedBubbles   []string      `json:"added_bubbles"`
+	RemovedBubbles []string      `json:"removed_bubbles"`
+	ChangedStates  []stateChange `json:"changed_states"`
+}
+
+func (d projectDiff) addedPair(dep dep) bool {
+	return slices.Contains(d.AddedPairs, dep)
+}
+
+// Empty tells whether nothing changed. It is exported for the templates.
+func (d projectDiff) Empty() bool {
+	return len(d.AddedPairs)+len(d.RemovedPairs)+len(d.AddedBubbles)+len(d.RemovedBubbles)+len(d.ChangedStates) == 0
+}
+
+func diffStates(base, current projectState) projectDiff {
+	diff := projectDiff{
+		AddedPairs:     []dep{},
+		RemovedPairs:   []dep{},
+		AddedBubbles:   []string{},
+		RemovedBubbles: []string{},
+		ChangedStates:  []stateChange{},
+	}
+	for _, dep := range current.Pairs {
+		if !slices.Contains(base.Pairs, dep) {
+			diff.AddedPairs = append(diff.AddedPairs, dep)
+		}
+	}
+	for _, dep := range base.Pairs {
+		if !slices.Contains(current.Pairs, dep) {
+			diff.RemovedPairs = append(diff.RemovedPairs, dep)
+		}
+	}
+	before := make(map[string]bubbleState)
+	for _, bubble := range knownBubbles(base.Pairs, base.States) {
+		before[bubble.Bubble] = bubble.State
+	}
+	after := knownBubbles(current.Pairs, current.States)
+	for _, bubble := range after {
+		old, ok := before[bubble.Bubble]
+		switch {
+		case !ok:
+			diff.AddedBubbles = append(diff.AddedBubbles, bubble.Bubble)
+		case old != bubble.State:
+			diff.ChangedStates = append(diff.ChangedStates, stateChange{Bubble: bubble.Bubble, Old: old, New: bubble.State})
+		}
+		delete(before, bubble.Bubble)
+	}
+	for name := range before {
+		diff.RemovedBubbles = append(diff.RemovedBubbles, name)
+	}
+	sort.Strings(diff.RemovedBubbles)
+	return diff
+}
+
+func createSnapshot(q queryer, pID int64, name string) (snapshot, error) {
+	state, err := captureState(q, pID)
+	if err != nil {
+		return snapshot{}, err
+	}
+	buf, err := json.Marshal(state)
+	if err != nil {
+		return snapshot{}, err
+	}
+	s := snapshot{Project: pID, Name: name, At: time.Now().UTC(), State: &state}
+	result, err := q.Exec("insert into snapshots (project, name, at, state) values (?, ?, ?, ?)", pID, name, s.At, buf)
+	if err != nil {
+		return snapshot{}, err
+	}
+	s.ID, err = result.LastInsertId()
+	return s, err
+}
+
+// listSnapshots returns the project's snapshots, newest first, without
+// their states.
+func listSnapshots(q queryer, pID int64) ([]snapshot, error) {
+	rows, err := q.Query("select id, project, name, at from snapshots where project = ? order by id desc", pID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	snapshots := []snapshot{}
+	for rows.Next() {
+		var s snapshot
+		if err := rows.Scan(&s.ID, &s.Project, &s.Name, &s.At); err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, s)
+	}
+	return snapshots, rows.Err()
+}
+
+func loadSnapshot(q queryer, pID, sID int64) (snapshot, error) {
+	var (
+		s   snapshot
+		buf []byte
+	)
+	err := q.QueryRow("select id, project, name, at, state from snapshots where project = ? and id = ?", pID, sID).Scan(&s.ID, &s.Project, &s.Name, &s.At, &buf)
+	if errors.Is(err, sql.ErrNoRows) {
+		return s, errNotFound
+	} else if err != nil {
+		return s, err
+	}
+	s.State = new(projectState)
+	return s, json.Unmarshal(buf, s.State)
+}
+
+// deleteSnapshot reports whether the snapshot existed.
+func deleteSnapshot(q queryer, pID, sID int64) (bool, error) {
+	result, err := q.Exec("delete from snapshots where project = ? and id = ?", pID, sID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
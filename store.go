@@ -0,0 +1,569 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// errNotFound is returned when a project or bubble does not exist.
+var errNotFound = errors.New("not found")
+
+// queryer is implemented by both *sql.DB and *sql.Tx.
+type queryer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+const projectColumns = "project, name, aborted_unblocks, strict, start"
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanProject(row scanner) (project, error) {
+	var p project
+	err := row.Scan(&p.ID, &p.Name, &p.AbortedUnblocks, &p.Strict, &p.Start)
+	return p, err
+}
+
+// listProjects returns the projects the user is a member of.
+func listProjects(q queryer, userID int64) ([]project, error) {
+	rows, err := q.Query("select "+projectColumns+" from projects where project in (select project from members where user = ?) order by project", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	projects := []project{}
+	for rows.Next() {
+		project, err := scanProject(rows)
+		if err != nil {
+			return nil, err
+		}
+		projects = append(projects, project)
+	}
+	return projects, rows.Err()
+}
+
+func loadProject(q queryer, pID int64) (project, error) {
+	p, err := scanProject(q.QueryRow("select "+projectColumns+" from projects where project = ?", pID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return p, errNotFound
+	}
+	return p, err
+}
+
+func createProject(j *journal, name string) (int64, error) {
+	result, err := j.Exec("insert into projects (name) values (?)", name)
+	if err != nil {
+		return 0, err
+	}
+	pID, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return pID, j.record(event{Project: pID, Kind: projectCreated, New: name})
+}
+
+// saveProject stores the project's name and settings.
+func saveProject(j *journal, p project) error {
+	old, err := loadProject(j, int64(p.ID))
+	if err != nil {
+		return err
+	}
+	if old == p {
+		return nil
+	}
+	if err := j.checkpoint(int64(p.ID)); err != nil {
+		return err
+	}
+	if _, err := j.Exec("update projects set name = ?, aborted_unblocks = ?, strict = ?, start = ? where project = ?", p.Name, p.AbortedUnblocks, p.Strict, p.Start, p.ID); err != nil {
+		return err
+	}
+	if old.Name != p.Name {
+		if err := j.record(event{Project: int64(p.ID), Kind: projectRenamed, Old: old.Name, New: p.Name}); err != nil {
+			return err
+		}
+	}
+	if old.settings() != p.settings() {
+		return j.record(event{Project: int64(p.ID), Kind: settingsChanged, Old: old.settings(), New: p.settings()})
+	}
+	return nil
+}
+
+func deleteProject(j *journal, pID int64) error {
+	p, err := loadProject(j, pID)
+	if err != nil {
+		return err
+	}
+	if _, err := j.Exec("delete from pairs where project = ?", pID); err != nil {
+		return err
+	}
+	if _, err := j.Exec("delete from bubbles where project = ?", pID); err != nil {
+		return err
+	}
+	if _, err := j.Exec("delete from projects where project = ?", pID); err != nil {
+		return err
+	}
+	if _, err := j.Exec("delete from undo_stack where project = ?", pID); err != nil {
+		return err
+	}
+	if _, err := j.Exec("delete from snapshots where project = ?", pID); err != nil {
+		return err
+	}
+	if _, err := j.Exec("delete from members where project = ?", pID); err != nil {
+		return err
+	}
+	if _, err := j.Exec("delete from shares where project = ?", pID); err != nil {
+		return err
+	}
+	if _, err := j.Exec("delete from api_tokens where project = ?", pID); err != nil {
+		return err
+	}
+	// The webhooks go last, as they are told about the deletion.
+	if err := j.record(event{Project: pID, Kind: projectDeleted, Old: p.Name}); err != nil {
+		return err
+	}
+	_, err = j.Exec("delete from webhooks where project = ?", pID)
+	return err
+}
+
+// loadPairs returns the project's pairs sorted by left and then right.
+func loadPairs(q queryer, pID int64) ([]dep, error) {
+	rows, err := q.Query("select left, right from pairs where project = ?", pID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	deps := []dep{}
+	for rows.Next() {
+		var dep dep
+		if err := rows.Scan(&dep.Left, &dep.Right); err != nil {
+			return nil, err
+		}
+		deps = append(deps, dep)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(deps, func(a, b int) bool {
+		if cmp := strings.Compare(deps[a].Left, deps[b].Left); cmp != 0 {
+			return cmp < 0
+		}
+		return deps[a].Right < deps[b].Right
+	})
+	return deps, nil
+}
+
+// loadStates returns the recorded state of every bubble of the project,
+// including the ones that are no longer part of any pair.
+func loadStates(q queryer, pID int64) (map[string]bubbleState, error) {
+	rows, err := q.Query("select bubble, state from bubbles where project = ?", pID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	states := make(map[string]bubbleState)
+	for rows.Next() {
+		var bubble bubble
+		if err := rows.Scan(&bubble.Bubble, &bubble.State); err != nil {
+			return nil, err
+		}
+		states[bubble.Bubble] = bubble.State
+	}
+	return states, rows.Err()
+}
+
+func loadGraph(q queryer, pID int64) ([]dep, map[string]bubbleState, error) {
+	deps, err := loadPairs(q, pID)
+	if err != nil {
+		return nil, nil, err
+	}
+	states, err := loadStates(q, pID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return deps, states, nil
+}
+
+// knownBubbles lists, sorted, every bubble mentioned in deps along with its
+// state. Bubbles without a recorded state are reported as initial.
+func knownBubbles(deps []dep, states map[string]bubbleState) []bubble {
+	idx := make(map[string]struct{})
+	for _, dep := range deps {
+		idx[dep.Left] = struct{}{}
+		idx[dep.Right] = struct{}{}
+	}
+	bubbles := make([]bubble, 0, len(idx))
+	for name := range idx {
+		state := states[name]
+		if state == "" {
+			state = initial
+		}
+		bubbles = append(bubbles, bubble{Bubble: name, State: state})
+	}
+	sort.Slice(bubbles, func(a, b int) bool {
+		return bubbles[a].Bubble < bubbles[b].Bubble
+	})
+	return bubbles
+}
+
+// insertPair stores left -> right. It refuses pairs that would close a loop
+// with a *cycleError, and reports whether the pair is new.
+func insertPair(j *journal, pID int64, left, right string) (bool, error) {
+	deps, err := loadPairs(j, pID)
+	if err != nil {
+		return false, err
+	}
+	if slices.Contains(deps, dep{Left: left, Right: right}) {
+		return false, nil
+	}
+	if err := checkAcyclic(deps, left, right); err != nil {
+		return false, err
+	}
+	return addPair(j, pID, left, right)
+}
+
+// addPair stores left -> right without looking for loops. It reports
+// whether the pair is new.
+func addPair(j *journal, pID int64, left, right string) (bool, error) {
+	if err := j.checkpoint(pID); err != nil {
+		return false, err
+	}
+	result, err := j.Exec("insert into pairs (project, left, right) values (?, ?, ?) on conflict (project, left, right) do nothing", pID, left, right)
+	if err != nil {
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+	return true, j.record(event{Project: pID, Kind: pairAdded, Left: left, Right: right})
+}
+
+// removePair deletes left -> right. It reports whether the pair existed.
+func removePair(j *journal, pID int64, left, right string) (bool, error) {
+	if err := j.checkpoint(pID); err != nil {
+		return false, err
+	}
+	result, err := j.Exec("delete from pairs where left = ? and right = ? and project = ?", left, right, pID)
+	if err != nil {
+		return false, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return false, err
+	}
+	return true, j.record(event{Project: pID, Kind: pairRemoved, Left: left, Right: right})
+}
+
+// renameBubble returns errNotFound if no pair has the bubble.
+func renameBubble(j *journal, pID int64, from, to string) error {
+	if from == to {
+		return nil
+	}
+	if err := j.checkpoint(pID); err != nil {
+		return err
+	}
+	var renamed int64
+	for _, query := range []string{
+		"update pairs set left = ? where project = ? and left = ?",
+		"update pairs set right = ? where project = ? and right = ?",
+	} {
+		result, err := j.Exec(query, to, pID, from)
+		if err != nil {
+			return err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		renamed += n
+	}
+	if renamed == 0 {
+		return fmt.Errorf("bubble %q: %w", from, errNotFound)
+	}
+	if err := moveBubbleRow(j, pID, from, to); err != nil {
+		return err
+	}
+	return j.record(event{Project: pID, Kind: bubbleRenamed, Bubble: from, Old: from, New: to})
+}
+
+// moveBubbleRow renames the state, estimate and fields of a bubble. When
+// the new name is taken, the bubble joins the existing one, which keeps
+// its state and takes the estimate and fields it lacks.
+func moveBubbleRow(j *journal, pID int64, from, to string) error {
+	var taken bool
+	if err := j.QueryRow("select count(*) > 0 from bubbles where project = ? and bubble = ?", pID, to).Scan(&taken); err != nil {
+		return err
+	}
+	if !taken {
+		_, err := j.Exec("update bubbles set bubble = ? where project = ? and bubble = ?", to, pID, from)
+		return err
+	}
+	fromInfo, err := bubbleInfoOf(j, pID, from)
+	if err != nil {
+		return err
+	}
+	toInfo, err := bubbleInfoOf(j, pID, to)
+	if err != nil {
+		return err
+	}
+	merged := mergeInfo(toInfo, fromInfo)
+	_, err = j.Exec(`
+		update bubbles set
+			description = ?, assignee = ?, due = ?, url = ?, tags = ?,
+			duration = case when duration = 0 then coalesce((select duration from bubbles where project = ? and bubble = ?), 0) else duration end
+		where project = ? and bubble = ?
+	`, merged.Description, merged.Assignee, merged.Due, merged.URL, strings.Join(merged.Tags, ","), pID, from, pID, to)
+	if err != nil {
+		return err
+	}
+	_, err = j.Exec("delete from bubbles where project = ? and bubble = ?", pID, from)
+	return err
+}
+
+// deleteBubble removes every pair the bubble takes part in.
+func deleteBubble(j *journal, pID int64, name string) error {
+	deps, err := loadPairs(j, pID)
+	if err != nil {
+		return err
+	}
+	for _, dep := range deps {
+		if dep.Left != name && dep.Right != name {
+			continue
+		}
+		if _, err := removePair(j, pID, dep.Left, dep.Right); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func bubbleStateOf(q queryer, pID int64, name string) (bubbleState, error) {
+	var state bubbleState
+	err := q.QueryRow("select state from bubbles where project = ? and bubble = ?", pID, name).Scan(&state)
+	if errors.Is(err, sql.ErrNoRows) {
+		return initial, nil
+	}
+	return state, err
+}
+
+func setBubbleState(q queryer, pID int64, name string, state bubbleState) error {
+	_, err := q.Exec(`
+		insert into bubbles (project, bubble, state) values (?, ?, ?)
+			on conflict (project, bubble) do update set state = excluded.state
+	`, pID, name, state)
+	return err
+}
+
+// maxDuration is the longest estimate a bubble can have, ten years, so that
+// a typo cannot make the Gantt chart span millennia.
+const maxDuration = 3650
+
+var errInvalidDuration = fmt.Errorf("duration must be a number between 0 and %v", maxDuration)
+
+func validDuration(d float64) bool {
+	return d >= 0 && d <= maxDuration && !math.IsNaN(d)
+}
+
+// parseDuration reads a bubble's estimate. An empty string means no estimate.
+func parseDuration(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	d, err := strconv.ParseFloat(s, 64)
+	if err != nil || !validDuration(d) {
+		return 0, errInvalidDuration
+	}
+	return d, nil
+}
+
+// formatDuration writes an estimate the way parseDuration reads it.
+func formatDuration(d float64) string {
+	return strconv.FormatFloat(d, 'f', -1, 64)
+}
+
+// loadDurations returns the estimate of every bubble of the project that
+// has one.
+func loadDurations(q queryer, pID int64) (map[string]float64, error) {
+	rows, err := q.Query("select bubble, duration from bubbles where project = ? and duration > 0", pID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	durations := make(map[string]float64)
+	for rows.Next() {
+		var (
+			name string
+			d    float64
+		)
+		if err := rows.Scan(&name, &d); err != nil {
+			return nil, err
+		}
+		durations[name] = d
+	}
+	return durations, rows.Err()
+}
+
+func bubbleDurationOf(q queryer, pID int64, name string) (float64, error) {
+	var d float64
+	err := q.QueryRow("select duration from bubbles where project = ? and bubble = ?", pID, name).Scan(&d)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return d, err
+}
+
+// changeBubbleDuration sets the estimate of the bubble; zero removes it.
+func changeBubbleDuration(j *journal, pID int64, name string, d float64) error {
+	if !validDuration(d) {
+		return errInvalidDuration
+	}
+	old, err := bubbleDurationOf(j, pID, name)
+	if err != nil {
+		return err
+	}
+	if old == d {
+		return nil
+	}
+	return writeBubbleDuration(j, pID, name, old, d)
+}
+
+func writeBubbleDuration(j *journal, pID int64, name string, old, d float64) error {
+	if err := j.checkpoint(pID); err != nil {
+		return err
+	}
+	_, err := j.Exec(`
+		insert into bubbles (project, bubble, state, duration) values (?, ?, ?, ?)
+			on conflict (project, bubble) do update set duration = excluded.duration
+	`, pID, name, initial, d)
+	if err != nil {
+		return err
+	}
+	return j.record(event{Project: pID, Kind: durationChanged, Bubble: name, Old: formatDuration(old), New: formatDuration(d)})
+}
+
+// blockedError reports a state change refused by a strict project.
+type blockedError struct {
+	Bubble   string
+	State    bubbleState
+	Blockers []string
+}
+
+func (e *blockedError) Error() string {
+	return fmt.Sprintf("%v cannot be %v: waiting for %v", e.Bubble, e.State, strings.Join(e.Blockers, ", "))
+}
+
+// changeBubbleState moves the bubble to the given state. In strict projects,
+// bubbles cannot be started or done while any of their predecessors is
+// pending; such changes fail with a *blockedError.
+func changeBubbleState(j *journal, pID int64, name string, state bubbleState) error {
+	old, err := bubbleStateOf(j, pID, name)
+	if err != nil {
+		return err
+	}
+	if old == state {
+		return nil
+	}
+	if state == started || state == done {
+		p, err := loadProject(j, pID)
+		if err != nil {
+			return err
+		}
+		if p.Strict {
+			deps, states, err := loadGraph(j, pID)
+			if err != nil {
+				return err
+			}
+			if blockers := blockersOf(deps, states, name, p.AbortedUnblocks); len(blockers) > 0 {
+				return &blockedError{Bubble: name, State: state, Blockers: blockers}
+			}
+		}
+	}
+	return writeBubbleState(j, pID, name, old, state)
+}
+
+// writeBubbleState records the move of a bubble from old to state, without
+// any further checks.
+func writeBubbleState(j *journal, pID int64, name string, old, state bubbleState) error {
+	if err := j.checkpoint(pID); err != nil {
+		return err
+	}
+	if err := setBubbleState(j, pID, name, state); err != nil {
+		return err
+	}
+	return j.record(event{Project: pID, Kind: stateChanged, Bubble: name, Old: string(old), New: string(state)})
+}
+
+// flipBubble moves the bubble to its next state and returns it.
+func flipBubble(j *journal, pID int64, name string) (bubbleState, error) {
+	state, err := bubbleStateOf(j, pID, name)
+	if err != nil {
+		return "", err
+	}
+	next := state.next()
+	return next, changeBubbleState(j, pID, name, next)
+}
+
+// migrate creates the tables, or brings those of an earlier version of the
+// schema up to date.
+func migrate(db *sql.DB) error {
+	const schema = `
+	create table if not exists pairs (project bigint, left text, right text);
+	create table if not exists bubbles (project bigint, bubble text, state text);
+	create unique index if not exists bubbles_project_bubble ON bubbles (project, bubble);
+	create table if not exists projects (project integer primary key autoincrement, name text);
+	create unique index if not exists pairs_unique on pairs (project, left, right);
+	create table if not exists events (id integer primary key autoincrement, project bigint, at timestamp, actor text, kind text, bubble text, left text, right text, old text, new text);
+	create index if not exists events_project on events (project, id);
+	create table if not exists undo_stack (id integer primary key autoincrement, project bigint, stack text, label text, state blob);
+	create index if not exists undo_stack_project on undo_stack (project, stack, id);
+	create table if not exists snapshots (id integer primary key autoincrement, project bigint, name text, at timestamp, state blob);
+	create table if not exists users (id integer primary key autoincrement, name text unique, password blob, created timestamp);
+	create table if not exists sessions (token text primary key, user bigint, expires timestamp);
+	create table if not exists members (project bigint, user bigint, role text, primary key (project, user));
+	create table if not exists api_tokens (id integer primary key autoincrement, user bigint, project bigint, name text, token text unique, created timestamp, expires timestamp, last_used timestamp);
+	create table if not exists webhooks (id integer primary key autoincrement, project bigint, url text, secret text, events text, created timestamp);
+	create table if not exists deliveries (id integer primary key autoincrement, webhook bigint, project bigint, kind text, url text, secret text, payload blob, created timestamp, attempts integer not null default 0, next_attempt timestamp, delivered timestamp, status integer not null default 0, error text not null default '');
+	create table if not exists shares (id integer primary key autoincrement, project bigint, name text, token text unique, created timestamp);
+	`
+	if _, err := db.Exec(schema); err != nil {
+		return err
+	}
+	columns := []struct{ table, column, decl string }{
+		{"projects", "aborted_unblocks", "boolean not null default false"},
+		{"projects", "strict", "boolean not null default false"},
+		{"events", "via", "text not null default ''"},
+		{"bubbles", "duration", "real not null default 0"},
+		{"projects", "start", "text not null default ''"},
+	}
+	for _, column := range []string{"description", "assignee", "due", "url", "tags"} {
+		columns = append(columns, struct{ table, column, decl string }{"bubbles", column, "text not null default ''"})
+	}
+	for _, c := range columns {
+		if err := addColumn(db, c.table, c.column, c.decl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addColumn adds a column to a table created by an earlier version of the
+// schema, doing nothing if the column already exists.
+func addColumn(db *sql.DB, table, column, decl string) error {
+	var n int
+	if err := db.QueryRow("select count(*) from pragma_table_info(?) where name = ?", table, column).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	_, err := db.Exec(fmt.Sprintf("alter table %s add column %s %s", table, column, decl))
+	return err
+}
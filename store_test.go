@@ -0,0 +1,100 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// newTestDB returns a database with the current schema, removed when the
+// test ends.
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "state.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// newTestProject creates a project holding the pairs.
+func newTestProject(t *testing.T, db *sql.DB, pairs ...dep) int64 {
+	t.Helper()
+	var pID int64
+	err := withJournal(db, "test", func(j *journal) error {
+		var err error
+		if pID, err = createProject(j, t.Name()); err != nil {
+			return err
+		}
+		for _, p := range pairs {
+			if _, err := insertPair(j, pID, p.Left, p.Right); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pID
+}
+
+func TestInsertPair(t *testing.T) {
+	chain := []dep{{Left: "a", Right: "b"}, {Left: "b", Right: "c"}}
+	tests := []struct {
+		name        string
+		left, right string
+		added       bool
+		cycle       []string
+	}{
+		{"new pair", "c", "d", true, nil},
+		{"shortcut", "a", "c", true, nil},
+		{"existing pair", "a", "b", false, nil},
+		{"self loop", "a", "a", false, []string{"a", "a"}},
+		{"back edge", "b", "a", false, []string{"b", "a", "b"}},
+		{"long loop", "c", "a", false, []string{"c", "a", "b", "c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			pID := newTestProject(t, db, chain...)
+			var added bool
+			err := withJournal(db, "test", func(j *journal) error {
+				var err error
+				added, err = insertPair(j, pID, tt.left, tt.right)
+				return err
+			})
+			var cycleErr *cycleError
+			if tt.cycle != nil {
+				if !errors.As(err, &cycleErr) {
+					t.Fatalf("insertPair(%v, %v) = %v, want a cycle error", tt.left, tt.right, err)
+				}
+				if !slices.Equal(cycleErr.Path, tt.cycle) {
+					t.Errorf("cycle = %v, want %v", cycleErr.Path, tt.cycle)
+				}
+			} else if err != nil {
+				t.Fatalf("insertPair(%v, %v): %v", tt.left, tt.right, err)
+			}
+			if added != tt.added {
+				t.Errorf("added = %v, want %v", added, tt.added)
+			}
+			deps, err := loadPairs(db, pID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := len(chain)
+			if tt.added {
+				want++
+			}
+			if len(deps) != want {
+				t.Errorf("got %v pairs, want %v: %v", len(deps), want, deps)
+			}
+		})
+	}
+}
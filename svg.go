@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// renderSVG draws a laid out graph the way Graphviz does, so that the page
+// styles and scripts work the same with either renderer.
+func renderSVG(l *layout) []byte {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, `<svg width="%vpt" height="%vpt" viewBox="0 0 %v %v" xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink">`+"\n",
+		num(l.Width), num(l.Height), num(l.Width), num(l.Height))
+	fmt.Fprintln(buf, `<g id="graph0" class="graph">`)
+	fmt.Fprintf(buf, `<polygon fill="white" stroke="none" points="0,0 %v,0 %v,%v 0,%v"/>`+"\n", num(l.Width), num(l.Width), num(l.Height), num(l.Height))
+	for i, c := range l.Clusters {
+		writeSVGCluster(buf, i+1, c)
+	}
+	for i, e := range l.Edges {
+		writeSVGEdge(buf, i+1, e)
+	}
+	for i, n := range l.Nodes {
+		writeSVGNode(buf, i+1, n)
+	}
+	fmt.Fprintln(buf, "</g>")
+	fmt.Fprintln(buf, "</svg>")
+	return buf.Bytes()
+}
+
+func writeSVGCluster(buf *bytes.Buffer, id int, c *layoutCluster) {
+	fmt.Fprintf(buf, `<g id="clust%v" class="cluster">`+"\n", id)
+	fmt.Fprintf(buf, "<title>%v</title>\n", html.EscapeString(c.ID))
+	fmt.Fprintf(buf, `<polygon fill="none" stroke="black" points="%v,%v %v,%v %v,%v %v,%v %v,%v"/>`+"\n",
+		num(c.Min.X), num(c.Min.Y), num(c.Max.X), num(c.Min.Y), num(c.Max.X), num(c.Max.Y), num(c.Min.X), num(c.Max.Y), num(c.Min.X), num(c.Min.Y))
+	if c.Label != "" {
+		fmt.Fprintf(buf, `<text text-anchor="middle" x="%v" y="%v" font-family="Times,serif" font-size="%v">%v</text>`+"\n",
+			num((c.Min.X+c.Max.X)/2), num(c.Min.Y+layoutFontSize), num(layoutFontSize), html.EscapeString(c.Label))
+	}
+	fmt.Fprintln(buf, "</g>")
+}
+
+func writeSVGEdge(buf *bytes.Buffer, id int, e *layoutEdge) {
+	color := attrOr(e.Attrs, "color", "black")
+	width := penWidth(e.Attrs)
+	fmt.Fprintf(buf, `<g id="edge%v" class="edge">`+"\n", id)
+	fmt.Fprintf(buf, "<title>%v&#45;&gt;%v</title>\n", html.EscapeString(e.From), html.EscapeString(e.To))
+	pts := e.Points
+	tip := pts[len(pts)-1]
+	from := pts[len(pts)-2]
+	// Aim the arrow along the last stretch of the edge and shorten the
+	// line so it ends where the arrowhead begins.
+	dx, dy := tip.X-from.X, tip.Y-from.Y
+	length := math.Hypot(dx, dy)
+	if length == 0 {
+		length = 1
+	}
+	ux, uy := dx/length, dy/length
+	const arrowLength, arrowWidth = 10.0, 3.5
+	base := point{X: tip.X - ux*arrowLength, Y: tip.Y - uy*arrowLength}
+	line := append(pts[:len(pts)-1:len(pts)-1], base)
+	fmt.Fprintf(buf, `<path fill="none" stroke="%v" stroke-width="%v"%v d="%v"/>`+"\n",
+		html.EscapeString(color), num(width), dashArray(e.Attrs), curvePath(line))
+	fmt.Fprintf(buf, `<polygon fill="%v" stroke="%v" stroke-width="%v" points="%v,%v %v,%v %v,%v"/>`+"\n",
+		html.EscapeString(color), html.EscapeString(color), num(width),
+		num(tip.X), num(tip.Y),
+		num(base.X-uy*arrowWidth), num(base.Y+ux*arrowWidth),
+		num(base.X+uy*arrowWidth), num(base.Y-ux*arrowWidth))
+	fmt.Fprintln(buf, "</g>")
+}
+
+func writeSVGNode(buf *bytes.Buffer, id int, n *layoutNode) {
+	fmt.Fprintf(buf, `<g id="node%v" class="node">`+"\n", id)
+	fmt.Fprintf(buf, "<title>%v</title>\n", html.EscapeString(n.ID))
+	href := attrOr(n.Attrs, "href", n.Attrs["URL"])
+	tooltip := n.Attrs["tooltip"]
+	anchor := href != "" || tooltip != ""
+	switch {
+	case href != "":
+		fmt.Fprintf(buf, `<g id="a_node%v"><a xlink:href="%v" xlink:title="%v">`+"\n", id, html.EscapeString(href), html.EscapeString(attrOr(n.Attrs, "tooltip", n.ID)))
+	case tooltip != "":
+		fmt.Fprintf(buf, `<g id="a_node%v"><a xlink:title="%v">`+"\n", id, html.EscapeString(tooltip))
+	}
+
+	style := n.Attrs["style"]
+	fill := "none"
+	if strings.Contains(style, "filled") {
+		fill = attrOr(n.Attrs, "fillcolor", attrOr(n.Attrs, "color", "lightgrey"))
+	}
+	stroke := attrOr(n.Attrs, "color", "black")
+	width := penWidth(n.Attrs)
+	peripheries := 1
+	if v, err := strconv.Atoi(n.Attrs["peripheries"]); err == nil {
+		peripheries = v
+	}
+	for i := 0; i < peripheries; i++ {
+		grow := float64(i) * 4
+		shapeFill := fill
+		if i > 0 {
+			shapeFill = "none"
+		}
+		attrs := fmt.Sprintf(`fill="%v" stroke="%v" stroke-width="%v"%v`, html.EscapeString(shapeFill), html.EscapeString(stroke), num(width), dashArray(n.Attrs))
+		if isBox(n.Attrs) {
+			x0, y0 := n.Pos.X-n.W/2-grow, n.Pos.Y-n.H/2-grow
+			x1, y1 := n.Pos.X+n.W/2+grow, n.Pos.Y+n.H/2+grow
+			fmt.Fprintf(buf, `<polygon %v points="%v,%v %v,%v %v,%v %v,%v %v,%v"/>`+"\n", attrs,
+				num(x1), num(y0), num(x0), num(y0), num(x0), num(y1), num(x1), num(y1), num(x1), num(y0))
+		} else {
+			fmt.Fprintf(buf, `<ellipse %v cx="%v" cy="%v" rx="%v" ry="%v"/>`+"\n", attrs,
+				num(n.Pos.X), num(n.Pos.Y), num(n.W/2+grow), num(n.H/2+grow))
+		}
+	}
+
+	fontColor := attrOr(n.Attrs, "fontcolor", "black")
+	lineHeight := layoutFontSize + 3
+	y := n.Pos.Y - float64(len(n.Label)-1)*lineHeight/2 + layoutFontSize*0.3
+	for _, line := range n.Label {
+		fmt.Fprintf(buf, `<text text-anchor="middle" x="%v" y="%v" font-family="Times,serif" font-size="%v" fill="%v">%v</text>`+"\n",
+			num(n.Pos.X), num(y), num(layoutFontSize), html.EscapeString(fontColor), html.EscapeString(line))
+		y += lineHeight
+	}
+	if anchor {
+		fmt.Fprintln(buf, "</a>\n</g>")
+	}
+	fmt.Fprintln(buf, "</g>")
+}
+
+// curvePath draws a smooth curve through the points, using cubic Bézier
+// segments with Catmull-Rom tangents.
+func curvePath(pts []point) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "M%v,%v", num(pts[0].X), num(pts[0].Y))
+	if len(pts) == 2 {
+		fmt.Fprintf(&sb, " L%v,%v", num(pts[1].X), num(pts[1].Y))
+		return sb.String()
+	}
+	for i := 0; i < len(pts)-1; i++ {
+		p0, p1, p2, p3 := pts[max(i-1, 0)], pts[i], pts[i+1], pts[min(i+2, len(pts)-1)]
+		c1 := point{X: p1.X + (p2.X-p0.X)/6, Y: p1.Y + (p2.Y-p0.Y)/6}
+		c2 := point{X: p2.X - (p3.X-p1.X)/6, Y: p2.Y - (p3.Y-p1.Y)/6}
+		fmt.Fprintf(&sb, " C%v,%v %v,%v %v,%v", num(c1.X), num(c1.Y), num(c2.X), num(c2.Y), num(p2.X), num(p2.Y))
+	}
+	return sb.String()
+}
+
+func attrOr(attrs map[string]string, key, fallback string) string {
+	if v, ok := attrs[key]; ok && v != "" {
+		return v
+	}
+	return fallback
+}
+
+func penWidth(attrs map[string]string) float64 {
+	if v, err := strconv.ParseFloat(attrs["penwidth"], 64); err == nil && v >= 0 {
+		return v
+	}
+	return 1
+}
+
+func dashArray(attrs map[string]string) string {
+	switch style := attrs["style"]; {
+	case strings.Contains(style, "dashed"):
+		return ` stroke-dasharray="5,2"`
+	case strings.Contains(style, "dotted"):
+		return ` stroke-dasharray="1,5"`
+	}
+	return ""
+}
+
+// num formats a coordinate with at most two decimals.
+func num(f float64) string {
+	return strconv.FormatFloat(math.Round(f*100)/100, 'f', -1, 64)
+}
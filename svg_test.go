@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestRenderSVG(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{
+			name: "empty",
+			src:  `digraph { }`,
+			want: []string{`<svg width="16pt" height="16pt"`},
+		},
+		{
+			name: "nodes and edges",
+			src:  `digraph { a -> b }`,
+			want: []string{`<title>a</title>`, `<title>b</title>`, `<title>a&#45;&gt;b</title>`, `<ellipse fill="none" stroke="black"`},
+		},
+		{
+			name: "escaped names",
+			src:  `digraph { "<a & b>" -> "\"q\"" }`,
+			want: []string{`<title>&lt;a &amp; b&gt;</title>`, `<title>&#34;q&#34;</title>`},
+		},
+		{
+			name: "links and tooltips",
+			src:  `digraph { a [href="/flip?pID=1&bubble=a", tooltip="waits for b"] }`,
+			want: []string{`<a xlink:href="/flip?pID=1&amp;bubble=a" xlink:title="waits for b">`},
+		},
+		{
+			name: "fill, border and shape",
+			src:  `digraph { a [style=filled, fillcolor=green, peripheries=2, shape=box] }`,
+			want: []string{`<polygon fill="green"`, `<polygon fill="none"`},
+		},
+		{
+			name: "dashed red edge",
+			src:  `digraph { a -> b [color=red, style=dashed, penwidth=2] }`,
+			want: []string{`stroke="red" stroke-width="2" stroke-dasharray="5,2"`},
+		},
+		{
+			name: "clusters",
+			src:  `digraph { subgraph cluster_x { label="team x"; a } }`,
+			want: []string{`<g id="clust1" class="cluster">`, `>team x</text>`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := parseDOT(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := renderSVG(layoutGraph(g))
+			dec := xml.NewDecoder(bytes.NewReader(out))
+			for {
+				if _, err := dec.Token(); errors.Is(err, io.EOF) {
+					break
+				} else if err != nil {
+					t.Fatalf("invalid SVG: %v\n%s", err, out)
+				}
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(string(out), want) {
+					t.Errorf("missing %q in\n%s", want, out)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"slices"
+	"strings"
+)
+
+// parseTags splits a comma separated list of tags, as typed in the bubble
+// panel.
+func parseTags(s string) []string {
+	return normalizeTags(strings.Split(s, ","))
+}
+
+// normalizeTags trims, sorts and deduplicates tags, dropping empty ones.
+// Tags cannot hold commas, as that is how they are stored.
+func normalizeTags(tags []string) []string {
+	var out []string
+	for _, tag := range tags {
+		for _, t := range strings.Split(tag, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				out = append(out, t)
+			}
+		}
+	}
+	slices.Sort(out)
+	return slices.Compact(out)
+}
+
+// projectTags lists every tag used in the project.
+func projectTags(infos map[string]bubbleInfo) []string {
+	var tags []string
+	for _, info := range infos {
+		tags = append(tags, info.Tags...)
+	}
+	slices.Sort(tags)
+	return slices.Compact(tags)
+}
+
+// tagSlice picks the bubbles that carry any of the tags, and the bubbles
+// right before or after them. It returns nil when no tags are given, which
+// means everything.
+func tagSlice(deps []dep, infos map[string]bubbleInfo, tags []string) map[string]bool {
+	if len(tags) == 0 {
+		return nil
+	}
+	tagged := make(map[string]bool)
+	for name, info := range infos {
+		if hasAnyTag(info.Tags, tags) {
+			tagged[name] = true
+		}
+	}
+	visible := make(map[string]bool)
+	for name := range tagged {
+		visible[name] = true
+	}
+	for _, dep := range deps {
+		if tagged[dep.Left] {
+			visible[dep.Right] = true
+		}
+		if tagged[dep.Right] {
+			visible[dep.Left] = true
+		}
+	}
+	return visible
+}
+
+func hasAnyTag(tags, wanted []string) bool {
+	for _, tag := range tags {
+		if slices.Contains(wanted, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// clusterTag is the tag a bubble is grouped under when the graph is drawn
+// in clusters. A bubble can only sit in one cluster, so it goes with the
+// first of its tags that is being filtered on, or else its first tag.
+func clusterTag(tags, filter []string) string {
+	for _, tag := range tags {
+		if slices.Contains(filter, tag) {
+			return tag
+		}
+	}
+	if len(tags) > 0 {
+		return tags[0]
+	}
+	return ""
+}
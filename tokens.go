@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// apiToken lets scripts, such as CI jobs, act as the user who made it, by
+// sending it as "Authorization: Bearer <token>". A token made for a project
+// works only there. Only a hash of the token is kept, so it can be seen
+// once, when it is made.
+type apiToken struct {
+	ID   int64
+	Name string
+	// Project is the project the token is limited to, or 0 for all the
+	// projects of the user.
+	Project     int64
+	ProjectName string
+	Created     time.Time
+	// Expires and LastUsed are zero for never.
+	Expires  time.Time
+	LastUsed time.Time
+}
+
+var (
+	errTokenNotFound = errors.New("this API token does not exist or was revoked")
+	errTokenScope    = errors.New("this API token is limited to a single project")
+	errTokenExpiry   = errors.New("API tokens must expire in the future")
+	errNeedSession   = errors.New("API tokens cannot do this, sign in instead")
+)
+
+// createAPIToken returns the new token, which is valid until expires, or
+// forever if expires is zero.
+func createAPIToken(q queryer, userID, pID int64, name string, expires time.Time) (string, error) {
+	if !expires.IsZero() && !expires.After(time.Now()) {
+		return "", errTokenExpiry
+	}
+	if pID != 0 {
+		if have, err := roleOf(q, userID, pID); err != nil {
+			return "", err
+		} else if have == "" {
+			return "", errForbidden
+		}
+	}
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+	_, err = q.Exec(
+		"insert into api_tokens (user, project, name, token, created, expires) values (?, ?, ?, ?, ?, ?)",
+		userID, pID, name, hashToken(token), time.Now().UTC(), sql.NullTime{Time: expires.UTC(), Valid: !expires.IsZero()},
+	)
+	return token, err
+}
+
+// listAPITokens returns the user's tokens, newest first.
+func listAPITokens(q queryer, userID int64) ([]apiToken, error) {
+	rows, err := q.Query(`
+		select api_tokens.id, api_tokens.name, api_tokens.project, coalesce(projects.name, ''), api_tokens.created, api_tokens.expires, api_tokens.last_used
+			from api_tokens left join projects on projects.project = api_tokens.project
+			where api_tokens.user = ? order by api_tokens.id desc
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tokens := []apiToken{}
+	for rows.Next() {
+		var (
+			t                 apiToken
+			expires, lastUsed sql.NullTime
+		)
+		if err := rows.Scan(&t.ID, &t.Name, &t.Project, &t.ProjectName, &t.Created, &expires, &lastUsed); err != nil {
+			return nil, err
+		}
+		t.Expires, t.LastUsed = expires.Time, lastUsed.Time
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// revokeAPIToken reports whether the user had the token.
+func revokeAPIToken(q queryer, userID, tID int64) (bool, error) {
+	result, err := q.Exec("delete from api_tokens where user = ? and id = ?", userID, tID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// useAPIToken returns the token and the user it belongs to, noting when it
+// was last used.
+func useAPIToken(q queryer, token string) (apiToken, account, error) {
+	var (
+		t    apiToken
+		acct account
+	)
+	now := time.Now().UTC()
+	err := q.QueryRow(`
+		select api_tokens.id, api_tokens.project, users.id, users.name from api_tokens join users on users.id = api_tokens.user
+			where api_tokens.token = ? and (api_tokens.expires is null or api_tokens.expires > ?)
+	`, hashToken(token), now).Scan(&t.ID, &t.Project, &acct.ID, &acct.Name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return apiToken{}, account{}, errTokenNotFound
+	} else if err != nil {
+		return apiToken{}, account{}, err
+	}
+	if _, err := q.Exec("update api_tokens set last_used = ? where id = ?", now, t.ID); err != nil {
+		return apiToken{}, account{}, err
+	}
+	t.LastUsed = now
+	return t, acct, nil
+}
+
+// bearerToken returns the token of an "Authorization: Bearer" header.
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+type apiTokenKey struct{}
+
+// viaAPIToken tells whether the request was made with an API token rather
+// than a session.
+func viaAPIToken(r *http.Request) bool {
+	_, ok := r.Context().Value(apiTokenKey{}).(apiToken)
+	return ok
+}
+
+// tokenProject returns the project the request is limited to, when it was
+// made with a project token.
+func tokenProject(r *http.Request) (int64, bool) {
+	t, ok := r.Context().Value(apiTokenKey{}).(apiToken)
+	return t.Project, ok && t.Project != 0
+}
+
+// withAPIToken keeps the user and the token in the request context.
+func withAPIToken(r *http.Request, t apiToken, acct account) *http.Request {
+	ctx := context.WithValue(r.Context(), accountKey{}, acct)
+	ctx = context.WithValue(ctx, apiTokenKey{}, t)
+	return r.WithContext(ctx)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCreateAPIToken(t *testing.T) {
+	db := newTestDB(t)
+	pID := newTestProject(t, db)
+	other := newTestProject(t, db)
+	acct, err := createUser(db, "ann", "secret123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := setMember(db, pID, acct.ID, viewer); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		pID     int64
+		expires time.Time
+		err     error
+	}{
+		{"all projects, never expires", 0, time.Time{}, nil},
+		{"member project", pID, time.Time{}, nil},
+		{"expires tomorrow", 0, time.Now().Add(24 * time.Hour), nil},
+		{"expired", 0, time.Now().Add(-time.Minute), errTokenExpiry},
+		{"not a member", other, time.Time{}, errForbidden},
+		{"no such project", 999, time.Time{}, errForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token, err := createAPIToken(db, acct.ID, tt.pID, tt.name, tt.expires)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("createAPIToken = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			got, _, err := useAPIToken(db, token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Project != tt.pID {
+				t.Errorf("token is limited to project %v, want %v", got.Project, tt.pID)
+			}
+		})
+	}
+}
+
+func TestUseAPIToken(t *testing.T) {
+	db := newTestDB(t)
+	ann, err := createUser(db, "ann", "secret123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := createUser(db, "bob", "secret123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := createAPIToken(db, ann.ID, 0, "ci", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := listAPITokens(db, ann.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 1 || tokens[0].Name != "ci" || !tokens[0].LastUsed.IsZero() || tokens[0].Expires.IsZero() {
+		t.Fatalf("before use, tokens = %+v", tokens)
+	}
+	tID := tokens[0].ID
+
+	before := time.Now().UTC().Add(-time.Second)
+	got, acct, err := useAPIToken(db, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if acct.ID != ann.ID || acct.Name != "ann" || got.ID != tID {
+		t.Errorf("useAPIToken = %+v, %+v, want ann's token %v", got, acct, tID)
+	}
+	if tokens, err = listAPITokens(db, ann.ID); err != nil {
+		t.Fatal(err)
+	}
+	if lastUsed := tokens[0].LastUsed; lastUsed.Before(before) || lastUsed.After(time.Now().Add(time.Second)) {
+		t.Errorf("last used at %v, want now", lastUsed)
+	}
+	if _, _, err := useAPIToken(db, "nope"); !errors.Is(err, errTokenNotFound) {
+		t.Errorf("unknown token: useAPIToken = %v, want %v", err, errTokenNotFound)
+	}
+
+	if _, err := db.Exec("update api_tokens set expires = ? where id = ?", time.Now().UTC().Add(-time.Second), tID); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := useAPIToken(db, token); !errors.Is(err, errTokenNotFound) {
+		t.Errorf("expired token: useAPIToken = %v, want %v", err, errTokenNotFound)
+	}
+
+	if ok, err := revokeAPIToken(db, bob.ID, tID); err != nil || ok {
+		t.Errorf("revoking another user's token = %v, %v, want false", ok, err)
+	}
+	if ok, err := revokeAPIToken(db, ann.ID, tID); err != nil || !ok {
+		t.Errorf("revokeAPIToken = %v, %v, want true", ok, err)
+	}
+	if tokens, err := listAPITokens(db, ann.ID); err != nil || len(tokens) != 0 {
+		t.Errorf("after revoking, tokens = %+v, %v", tokens, err)
+	}
+}
+
+func TestTokenProject(t *testing.T) {
+	tests := []struct {
+		name    string
+		token   *apiToken
+		pID     int64
+		limited bool
+	}{
+		{"session", nil, 0, false},
+		{"all projects", &apiToken{ID: 1}, 0, false},
+		{"one project", &apiToken{ID: 1, Project: 7}, 7, true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		if tt.token != nil {
+			r = withAPIToken(r, *tt.token, account{ID: 1, Name: "ann"})
+		}
+		if viaAPIToken(r) != (tt.token != nil) {
+			t.Errorf("%v: viaAPIToken = %v", tt.name, viaAPIToken(r))
+		}
+		if pID, limited := tokenProject(r); pID != tt.pID || limited != tt.limited {
+			t.Errorf("%v: tokenProject = %v, %v, want %v, %v", tt.name, pID, limited, tt.pID, tt.limited)
+		}
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	tests := []struct {
+		header string
+		token  string
+		ok     bool
+	}{
+		{"", "", false},
+		{"Bearer abc", "abc", true},
+		{"bearer  abc ", "abc", true},
+		{"Basic YWxhZGRpbg==", "", false},
+		{"Bearer ", "", false},
+		{"Bearer", "", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Authorization", tt.header)
+		if token, ok := bearerToken(r); token != tt.token || ok != tt.ok {
+			t.Errorf("bearerToken(%q) = %q, %v, want %q, %v", tt.header, token, ok, tt.token, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"maps"
+	"slices"
+)
+
+// undoDepth is how many changes of each project can be undone.
+const undoDepth = 20
+
+const (
+	undoStack = "undo"
+	redoStack = "redo"
+)
+
+var (
+	errNothingToUndo = errors.New("nothing to undo")
+	errNothingToRedo = errors.New("nothing to redo")
+)
+
+// projectState is everything about a project that can be restored: its
+// name and settings, its pairs and the states and estimates of its bubbles.
+type projectState struct {
+	Project project                `json:"project"`
+	Pairs   []dep                  `json:"pairs"`
+	States  map[string]bubbleState `json:"states"`
+
+	// Durations and Info are nil in states saved before bubbles had
+	// estimates and fields; restoring them leaves the current ones alone.
+	// They are saved even when empty, so that an empty map stays apart
+	// from a missing one.
+	Durations map[string]float64    `json:"durations"`
+	Info      map[string]bubbleInfo `json:"info"`
+}
+
+func captureState(q queryer, pID int64) (projectState, error) {
+	p, err := loadProject(q, pID)
+	if err != nil {
+		return projectState{}, err
+	}
+	deps, states, err := loadGraph(q, pID)
+	if err != nil {
+		return projectState{}, err
+	}
+	durations, err := loadDurations(q, pID)
+	if err != nil {
+		return projectState{}, err
+	}
+	infos, err := loadInfos(q, pID)
+	if err != nil {
+		return projectState{}, err
+	}
+	return projectState{Project: p, Pairs: deps, States: states, Durations: durations, Info: infos}, nil
+}
+
+// restoreState brings the project back to the given state, recording every
+// difference as a regular mutation.
+func restoreState(j *journal, pID int64, state projectState) error {
+	current, err := captureState(j, pID)
+	if err != nil {
+		return err
+	}
+	for _, dep := range current.Pairs {
+		if !slices.Contains(state.Pairs, dep) {
+			if _, err := removePair(j, pID, dep.Left, dep.Right); err != nil {
+				return err
+			}
+		}
+	}
+	for _, dep := range state.Pairs {
+		if !slices.Contains(current.Pairs, dep) {
+			if _, err := addPair(j, pID, dep.Left, dep.Right); err != nil {
+				return err
+			}
+		}
+	}
+	names := maps.Clone(current.States)
+	maps.Copy(names, state.States)
+	for _, name := range slices.Sorted(maps.Keys(names)) {
+		old, ok := current.States[name]
+		if !ok {
+			old = initial
+		}
+		want, ok := state.States[name]
+		if !ok {
+			want = initial
+		}
+		if old != want {
+			if err := writeBubbleState(j, pID, name, old, want); err != nil {
+				return err
+			}
+		}
+	}
+	if state.Durations != nil {
+		names := maps.Clone(current.Durations)
+		maps.Copy(names, state.Durations)
+		for _, name := range slices.Sorted(maps.Keys(names)) {
+			if old, want := current.Durations[name], state.Durations[name]; old != want {
+				if err := writeBubbleDuration(j, pID, name, old, want); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if state.Info != nil {
+		names := maps.Clone(current.Info)
+		maps.Copy(names, state.Info)
+		for _, name := range slices.Sorted(maps.Keys(names)) {
+			if old, want := current.Info[name], state.Info[name]; !old.equal(want) {
+				if err := writeBubbleInfo(j, pID, name, old, want); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	p := state.Project
+	p.ID = uint64(pID)
+	return saveProject(j, p)
+}
+
+func pushState(q queryer, pID int64, stack, label string, state projectState) error {
+	buf, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec("insert into undo_stack (project, stack, label, state) values (?, ?, ?, ?)", pID, stack, label, buf)
+	return err
+}
+
+// peekState returns the label of the change on top of the stack, or an empty
+// string if the stack is empty.
+func peekState(q queryer, pID int64, stack string) (string, error) {
+	var label string
+	err := q.QueryRow("select label from undo_stack where project = ? and stack = ? order by id desc limit 1", pID, stack).Scan(&label)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return label, err
+}
+
+func popState(q queryer, pID int64, stack string) (string, projectState, error) {
+	var (
+		id    int64
+		label string
+		buf   []byte
+		state projectState
+	)
+	err := q.QueryRow("select id, label, state from undo_stack where project = ? and stack = ? order by id desc limit 1", pID, stack).Scan(&id, &label, &buf)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", state, errNotFound
+	} else if err != nil {
+		return "", state, err
+	}
+	if err := json.Unmarshal(buf, &state); err != nil {
+		return "", state, err
+	}
+	_, err = q.Exec("delete from undo_stack where id = ?", id)
+	return label, state, err
+}
+
+// saveCheckpoints pushes the state each project had before this journal
+// changed it onto the undo stack. A new change makes the redo stack
+// meaningless, so it is discarded.
+func (j *journal) saveCheckpoints() error {
+	for pID, state := range j.before {
+		label, ok := j.labels[pID]
+		if !ok {
+			continue
+		}
+		if err := pushState(j, pID, undoStack, label, state); err != nil {
+			return err
+		}
+		if _, err := j.Exec("delete from undo_stack where project = ? and stack = ?", pID, redoStack); err != nil {
+			return err
+		}
+		_, err := j.Exec(`
+			delete from undo_stack where project = ? and stack = ? and id not in (
+				select id from undo_stack where project = ? and stack = ? order by id desc limit ?
+			)
+		`, pID, undoStack, pID, undoStack, undoDepth)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// undo reverts the latest change of the project and returns its label.
+func undo(j *journal, pID int64) (string, error) {
+	label, err := travel(j, pID, undoStack, redoStack)
+	if errors.Is(err, errNotFound) {
+		return "", errNothingToUndo
+	}
+	return label, err
+}
+
+// redo reapplies the latest undone change of the project and returns its
+// label.
+func redo(j *journal, pID int64) (string, error) {
+	label, err := travel(j, pID, redoStack, undoStack)
+	if errors.Is(err, errNotFound) {
+		return "", errNothingToRedo
+	}
+	return label, err
+}
+
+// travel restores the state on top of the from stack, saving the current
+// state on top of the to stack.
+func travel(j *journal, pID int64, from, to string) (string, error) {
+	label, state, err := popState(j, pID, from)
+	if err != nil {
+		return "", err
+	}
+	current, err := captureState(j, pID)
+	if err != nil {
+		return "", err
+	}
+	if err := pushState(j, pID, to, label, current); err != nil {
+		return "", err
+	}
+	j.via = from
+	defer func() { j.via = "" }()
+	return label, restoreState(j, pID, state)
+}
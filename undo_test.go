@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"maps"
+	"reflect"
+	"testing"
+)
+
+func TestUndoRedo(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func(j *journal, pID int64) error
+	}{
+		{"add pair", func(j *journal, pID int64) error {
+			_, err := insertPair(j, pID, "c", "d")
+			return err
+		}},
+		{"remove pair", func(j *journal, pID int64) error {
+			_, err := removePair(j, pID, "b", "c")
+			return err
+		}},
+		{"change state", func(j *journal, pID int64) error {
+			return changeBubbleState(j, pID, "a", done)
+		}},
+		{"first duration", func(j *journal, pID int64) error {
+			return changeBubbleDuration(j, pID, "b", 3)
+		}},
+		{"first details", func(j *journal, pID int64) error {
+			return changeBubbleInfo(j, pID, "b", bubbleInfo{Assignee: "ann", Tags: []string{"ops"}})
+		}},
+		{"rename", func(j *journal, pID int64) error {
+			return renameBubble(j, pID, "b", "beta")
+		}},
+		{"delete", func(j *journal, pID int64) error {
+			return deleteBubble(j, pID, "c")
+		}},
+		{"settings", func(j *journal, pID int64) error {
+			p, err := loadProject(j, pID)
+			if err != nil {
+				return err
+			}
+			p.Name, p.Strict = "renamed", true
+			return saveProject(j, p)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			pID := newTestProject(t, db, dep{Left: "a", Right: "b"}, dep{Left: "b", Right: "c"})
+			step := func(fn func(j *journal) error) projectState {
+				t.Helper()
+				var state projectState
+				err := withJournal(db, "test", func(j *journal) error {
+					if err := fn(j); err != nil {
+						return err
+					}
+					var err error
+					state, err = captureState(j, pID)
+					return err
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				// Bubbles without a row are initial: undoing the first
+				// change of a bubble may leave its row behind.
+				maps.DeleteFunc(state.States, func(_ string, s bubbleState) bool { return s == initial })
+				return state
+			}
+			undoStep := func(j *journal) error {
+				_, err := undo(j, pID)
+				return err
+			}
+			redoStep := func(j *journal) error {
+				_, err := redo(j, pID)
+				return err
+			}
+
+			before := step(func(*journal) error { return nil })
+			after := step(func(j *journal) error { return tt.edit(j, pID) })
+			if reflect.DeepEqual(before, after) {
+				t.Fatal("the edit changed nothing")
+			}
+			if got := step(undoStep); !reflect.DeepEqual(got, before) {
+				t.Errorf("after undo:\ngot  %+v\nwant %+v", got, before)
+			}
+			if got := step(redoStep); !reflect.DeepEqual(got, after) {
+				t.Errorf("after redo:\ngot  %+v\nwant %+v", got, after)
+			}
+			if got := step(undoStep); !reflect.DeepEqual(got, before) {
+				t.Errorf("after undoing the redo:\ngot  %+v\nwant %+v", got, before)
+			}
+		})
+	}
+}
+
+func TestUndoRedoEmpty(t *testing.T) {
+	db := newTestDB(t)
+	pID := newTestProject(t, db)
+	err := withJournal(db, "test", func(j *journal) error {
+		_, err := undo(j, pID)
+		return err
+	})
+	if !errors.Is(err, errNothingToUndo) {
+		t.Errorf("undo = %v, want %v", err, errNothingToUndo)
+	}
+	err = withJournal(db, "test", func(j *journal) error {
+		_, err := redo(j, pID)
+		return err
+	})
+	if !errors.Is(err, errNothingToRedo) {
+		t.Errorf("redo = %v, want %v", err, errNothingToRedo)
+	}
+}
@@ -0,0 +1,563 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// bubbleReady is sent when all the predecessors of a bubble are done. It is
+// worked out from the graph, so it never shows in the history.
+const bubbleReady eventKind = "bubble ready"
+
+// webhookKinds are the events a webhook can subscribe to.
+var webhookKinds = []eventKind{stateChanged, pairAdded, pairRemoved, bubbleReady, projectDeleted}
+
+const (
+	// webhookAttempts is how many times a delivery is tried before giving
+	// up on it.
+	webhookAttempts = 8
+	// webhookBackoff is the wait before the first retry; it doubles with
+	// every attempt.
+	webhookBackoff = 10 * time.Second
+	webhookTimeout = 10 * time.Second
+	// webhookWorkers is how many webhooks are sent to at once.
+	webhookWorkers = 8
+	// deliveryLogSize is how many finished deliveries each project keeps.
+	deliveryLogSize = 100
+	// deliveriesShown is how many deliveries the project page lists.
+	deliveriesShown = 20
+)
+
+var (
+	errNoWebhookKinds = errors.New("pick at least one event")
+	errWebhookURL     = errors.New("webhook url must be an absolute http or https address")
+	errWebhookTarget  = errors.New("webhooks cannot be sent to loopback, private or link-local addresses")
+)
+
+// webhook posts the events of a project to URL, signed with Secret, so the
+// receiver can tell they came from here.
+type webhook struct {
+	ID      int64       `json:"id"`
+	Project int64       `json:"project"`
+	URL     string      `json:"url"`
+	Secret  string      `json:"secret"`
+	Kinds   []eventKind `json:"events"`
+	Created time.Time   `json:"created"`
+}
+
+// webhookPayload is the body of a delivery.
+type webhookPayload struct {
+	event
+	ProjectName string `json:"project_name"`
+}
+
+// delivery is an attempt, past or pending, to post an event to a webhook.
+// URL and Secret are copied from the webhook, so that the deletion of a
+// project can still be told.
+type delivery struct {
+	ID       int64
+	Webhook  int64
+	Project  int64
+	Kind     eventKind
+	URL      string
+	Secret   string
+	Payload  []byte
+	Created  time.Time
+	Attempts int
+	// NextAttempt is zero once the delivery has succeeded or given up.
+	NextAttempt time.Time
+	Delivered   time.Time
+	Status      int
+	Error       string
+}
+
+// State is for the templates.
+func (d delivery) State() string {
+	switch {
+	case !d.Delivered.IsZero():
+		return "delivered"
+	case !d.NextAttempt.IsZero() && d.Attempts == 0:
+		return "pending"
+	case !d.NextAttempt.IsZero():
+		return "retrying"
+	default:
+		return "failed"
+	}
+}
+
+func createWebhook(q queryer, pID int64, link string, kinds []eventKind) (webhook, error) {
+	u, err := url.Parse(strings.TrimSpace(link))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return webhook{}, errWebhookURL
+	}
+	if len(kinds) == 0 {
+		return webhook{}, errNoWebhookKinds
+	}
+	secret, err := newToken()
+	if err != nil {
+		return webhook{}, err
+	}
+	h := webhook{Project: pID, URL: u.String(), Secret: secret, Kinds: kinds, Created: time.Now().UTC()}
+	result, err := q.Exec("insert into webhooks (project, url, secret, events, created) values (?, ?, ?, ?, ?)", pID, h.URL, h.Secret, joinKinds(kinds), h.Created)
+	if err != nil {
+		return webhook{}, err
+	}
+	h.ID, err = result.LastInsertId()
+	return h, err
+}
+
+// parseWebhookKinds checks that every kind can be subscribed to.
+func parseWebhookKinds(values []string) ([]eventKind, error) {
+	var kinds []eventKind
+	for _, v := range values {
+		kind := eventKind(v)
+		if !slices.Contains(webhookKinds, kind) {
+			return nil, fmt.Errorf("unknown webhook event %q", v)
+		}
+		if !slices.Contains(kinds, kind) {
+			kinds = append(kinds, kind)
+		}
+	}
+	return kinds, nil
+}
+
+func joinKinds(kinds []eventKind) string {
+	s := make([]string, len(kinds))
+	for i, kind := range kinds {
+		s[i] = string(kind)
+	}
+	return strings.Join(s, ",")
+}
+
+func splitKinds(s string) []eventKind {
+	var kinds []eventKind
+	for _, kind := range strings.Split(s, ",") {
+		if kind != "" {
+			kinds = append(kinds, eventKind(kind))
+		}
+	}
+	return kinds
+}
+
+func listWebhooks(q queryer, pID int64) ([]webhook, error) {
+	rows, err := q.Query("select id, project, url, secret, events, created from webhooks where project = ? order by id", pID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	hooks := []webhook{}
+	for rows.Next() {
+		var (
+			h     webhook
+			kinds string
+		)
+		if err := rows.Scan(&h.ID, &h.Project, &h.URL, &h.Secret, &kinds, &h.Created); err != nil {
+			return nil, err
+		}
+		h.Kinds = splitKinds(kinds)
+		hooks = append(hooks, h)
+	}
+	return hooks, rows.Err()
+}
+
+// deleteWebhook reports whether the webhook existed. Its pending deliveries
+// are dropped.
+func deleteWebhook(q queryer, pID, hID int64) (bool, error) {
+	result, err := q.Exec("delete from webhooks where project = ? and id = ?", pID, hID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil || n == 0 {
+		return false, err
+	}
+	_, err = q.Exec("update deliveries set next_attempt = null, error = 'webhook deleted' where webhook = ? and next_attempt is not null", hID)
+	return true, err
+}
+
+// listDeliveries returns the latest deliveries of the project, newest
+// first.
+func listDeliveries(q queryer, pID int64, limit int) ([]delivery, error) {
+	rows, err := q.Query(`
+		select id, webhook, project, kind, url, created, attempts, next_attempt, delivered, status, error from deliveries
+			where project = ? order by id desc limit ?
+	`, pID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	deliveries := []delivery{}
+	for rows.Next() {
+		var (
+			d                      delivery
+			nextAttempt, delivered sql.NullTime
+		)
+		if err := rows.Scan(&d.ID, &d.Webhook, &d.Project, &d.Kind, &d.URL, &d.Created, &d.Attempts, &nextAttempt, &delivered, &d.Status, &d.Error); err != nil {
+			return nil, err
+		}
+		d.NextAttempt, d.Delivered = nextAttempt.Time, delivered.Time
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// queueWebhooks adds a delivery of the event for every webhook of its
+// project that subscribes to it. Deliveries are queued in the journal's
+// transaction, so they go out only if the change is committed.
+func queueWebhooks(j *journal, ev event) error {
+	if !slices.Contains(webhookKinds, ev.Kind) {
+		return nil
+	}
+	hooks, err := listWebhooks(j, ev.Project)
+	if err != nil || len(hooks) == 0 {
+		return err
+	}
+	name := ev.Old
+	if ev.Kind != projectDeleted {
+		p, err := loadProject(j, ev.Project)
+		if err != nil {
+			return err
+		}
+		name = p.Name
+	}
+	payload, err := json.Marshal(webhookPayload{event: ev, ProjectName: name})
+	if err != nil {
+		return err
+	}
+	for _, h := range hooks {
+		if !slices.Contains(h.Kinds, ev.Kind) {
+			continue
+		}
+		_, err := j.Exec(`
+			insert into deliveries (webhook, project, kind, url, secret, payload, created, next_attempt)
+				values (?, ?, ?, ?, ?, ?, ?, ?)
+		`, h.ID, ev.Project, ev.Kind, h.URL, h.Secret, payload, ev.At, ev.At)
+		if err != nil {
+			return err
+		}
+		j.queued = true
+	}
+	return nil
+}
+
+// noteReady remembers which bubbles of the project are ready before the
+// journal changes it, so queueReady can tell which ones became ready. It
+// does nothing for projects without webhooks that care.
+func (j *journal) noteReady(pID int64) error {
+	if _, ok := j.ready[pID]; ok {
+		return nil
+	}
+	var wanted bool
+	err := j.QueryRow("select count(*) > 0 from webhooks where project = ? and (',' || events || ',') like ?", pID, "%,"+string(bubbleReady)+",%").Scan(&wanted)
+	if err != nil {
+		return err
+	}
+	j.ready[pID] = nil
+	if !wanted {
+		return nil
+	}
+	ready, err := readySet(j, pID)
+	if err != nil {
+		return err
+	}
+	j.ready[pID] = ready
+	return nil
+}
+
+// queueReady sends a bubbleReady event for the bubbles that were waiting
+// on their predecessors before the journal ran and no longer are.
+func (j *journal) queueReady() error {
+	for _, pID := range slices.Sorted(maps.Keys(j.ready)) {
+		before := j.ready[pID]
+		if before == nil {
+			continue
+		}
+		if _, err := loadProject(j, pID); errors.Is(err, errNotFound) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		after, err := readySet(j, pID)
+		if err != nil {
+			return err
+		}
+		for _, name := range slices.Sorted(maps.Keys(after)) {
+			if wasReady, known := before[name]; known && !wasReady && after[name] {
+				ev := event{Project: pID, At: time.Now().UTC(), Actor: j.actor, Kind: bubbleReady, Bubble: name, Via: j.via}
+				if err := queueWebhooks(j, ev); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// readySet maps every bubble of the project to whether it is ready to
+// start.
+func readySet(q queryer, pID int64) (map[string]bool, error) {
+	p, err := loadProject(q, pID)
+	if err != nil {
+		return nil, err
+	}
+	deps, states, err := loadGraph(q, pID)
+	if err != nil {
+		return nil, err
+	}
+	set := make(map[string]bool)
+	for _, b := range knownBubbles(deps, states) {
+		set[b.Bubble] = false
+	}
+	for _, b := range readyBubbles(deps, states, p.AbortedUnblocks) {
+		set[b.Bubble] = true
+	}
+	return set, nil
+}
+
+// webhookWake tells the deliverer that withJournal queued deliveries.
+var webhookWake = make(chan struct{}, 1)
+
+func wakeWebhooks() {
+	select {
+	case webhookWake <- struct{}{}:
+	default:
+	}
+}
+
+// runWebhooks delivers the queued deliveries, retrying failed ones with
+// exponential backoff. It never returns.
+func runWebhooks(client *http.Client, db *sql.DB, dbMu *sync.Mutex) {
+	s := newWebhookSender(client, db, dbMu)
+	for {
+		next, err := s.deliverDue()
+		if err != nil {
+			log.Printf("cannot deliver webhooks: %v", err)
+			next = time.Now().Add(webhookBackoff)
+		}
+		wait := time.Hour
+		if !next.IsZero() {
+			wait = time.Until(next)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-webhookWake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// newWebhookClient returns the client webhooks are sent with. Unless
+// allowLocal is set, it refuses to connect to loopback, private and
+// link-local addresses, so that a webhook cannot reach the services on the
+// server's own network. The address is checked as it is dialed, after the
+// name is resolved, so neither DNS nor redirects get around it.
+func newWebhookClient(allowLocal bool) *http.Client {
+	dialer := &net.Dialer{Timeout: webhookTimeout}
+	if !allowLocal {
+		dialer.Control = refuseLocalAddress
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// Through a proxy, the proxy would be the address checked.
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: webhookTimeout, Transport: transport}
+}
+
+func refuseLocalAddress(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+	ip := addrPort.Addr().Unmap()
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+		return fmt.Errorf("%w: %v", errWebhookTarget, ip)
+	}
+	return nil
+}
+
+// webhookSender sends the deliveries of each webhook from a goroutine of
+// its own, so that an endpoint that is down or slow holds up only its own
+// deliveries. At most webhookWorkers of them send at once.
+type webhookSender struct {
+	client  *http.Client
+	db      *sql.DB
+	dbMu    *sync.Mutex
+	workers chan struct{}
+	wg      sync.WaitGroup
+	// busy holds the webhooks being sent to. It is guarded by dbMu.
+	busy map[int64]bool
+}
+
+func newWebhookSender(client *http.Client, db *sql.DB, dbMu *sync.Mutex) *webhookSender {
+	return &webhookSender{
+		client:  client,
+		db:      db,
+		dbMu:    dbMu,
+		workers: make(chan struct{}, webhookWorkers),
+		busy:    make(map[int64]bool),
+	}
+}
+
+// deliverDue starts sending the deliveries whose time has come, and returns
+// when the next one is due, or zero if none is pending. Webhooks that are
+// being sent to are left out: they wake the sender once they are done.
+func (s *webhookSender) deliverDue() (time.Time, error) {
+	s.dbMu.Lock()
+	defer s.dbMu.Unlock()
+	due, err := dueDeliveries(s.db, time.Now().UTC(), s.busy)
+	if err != nil {
+		return time.Time{}, err
+	}
+	byWebhook := make(map[int64][]delivery)
+	for _, d := range due {
+		byWebhook[d.Webhook] = append(byWebhook[d.Webhook], d)
+	}
+	for id, deliveries := range byWebhook {
+		s.busy[id] = true
+		s.wg.Add(1)
+		go s.send(id, deliveries)
+	}
+	var next time.Time
+	query, args := notBusy("select next_attempt from deliveries where next_attempt is not null", s.busy)
+	err = s.db.QueryRow(query+" order by next_attempt limit 1", args...).Scan(&next)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	return next, err
+}
+
+// send tries the webhook's deliveries in order.
+func (s *webhookSender) send(webhook int64, deliveries []delivery) {
+	defer s.wg.Done()
+	s.workers <- struct{}{}
+	for _, d := range deliveries {
+		status, err := sendWebhook(s.client, d)
+		if err != nil {
+			warnf("webhook delivery %v to %v failed: %v", d.ID, d.URL, err)
+		}
+		s.dbMu.Lock()
+		err = finishAttempt(s.db, d, status, err)
+		s.dbMu.Unlock()
+		if err != nil {
+			log.Printf("cannot log webhook delivery %v: %v", d.ID, err)
+		}
+	}
+	<-s.workers
+	s.dbMu.Lock()
+	delete(s.busy, webhook)
+	s.dbMu.Unlock()
+	wakeWebhooks()
+}
+
+// wait returns once the deliveries started so far are sent.
+func (s *webhookSender) wait() {
+	s.wg.Wait()
+}
+
+// notBusy narrows a query on deliveries to the webhooks that are not busy.
+func notBusy(query string, busy map[int64]bool) (string, []any) {
+	if len(busy) == 0 {
+		return query, nil
+	}
+	var args []any
+	for id := range busy {
+		args = append(args, id)
+	}
+	return query + " and webhook not in (?" + strings.Repeat(", ?", len(args)-1) + ")", args
+}
+
+func dueDeliveries(q queryer, now time.Time, busy map[int64]bool) ([]delivery, error) {
+	query, args := notBusy(`
+		select id, webhook, project, kind, url, secret, payload, attempts from deliveries
+			where next_attempt is not null and next_attempt <= ?`, busy)
+	rows, err := q.Query(query+" order by id limit 20", append([]any{now}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var due []delivery
+	for rows.Next() {
+		var d delivery
+		if err := rows.Scan(&d.ID, &d.Webhook, &d.Project, &d.Kind, &d.URL, &d.Secret, &d.Payload, &d.Attempts); err != nil {
+			return nil, err
+		}
+		due = append(due, d)
+	}
+	return due, rows.Err()
+}
+
+// sendWebhook posts the payload, signed as an HMAC-SHA256 of the body in
+// the X-Bubbles-Signature header. Anything but a 2xx answer is a failure.
+func sendWebhook(client *http.Client, d delivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "bubbles-webhook")
+	req.Header.Set("X-Bubbles-Event", string(d.Kind))
+	req.Header.Set("X-Bubbles-Delivery", strconv.FormatInt(d.ID, 10))
+	req.Header.Set("X-Bubbles-Signature", "sha256="+signPayload(d.Secret, d.Payload))
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected response %v", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+func signPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// finishAttempt logs the outcome of sending the delivery, scheduling the
+// next attempt if it failed and there are attempts left.
+func finishAttempt(q queryer, d delivery, status int, sendErr error) error {
+	now := time.Now().UTC()
+	attempts := d.Attempts + 1
+	var err error
+	switch {
+	case sendErr == nil:
+		_, err = q.Exec("update deliveries set attempts = ?, status = ?, error = '', next_attempt = null, delivered = ? where id = ?", attempts, status, now, d.ID)
+	case attempts >= webhookAttempts:
+		_, err = q.Exec("update deliveries set attempts = ?, status = ?, error = ?, next_attempt = null where id = ?", attempts, status, sendErr.Error(), d.ID)
+	default:
+		next := now.Add(webhookBackoff << (attempts - 1))
+		_, err = q.Exec("update deliveries set attempts = ?, status = ?, error = ?, next_attempt = ? where id = ? and next_attempt is not null", attempts, status, sendErr.Error(), next, d.ID)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = q.Exec(`
+		delete from deliveries where project = ? and next_attempt is null and id not in (
+			select id from deliveries where project = ? order by id desc limit ?
+		)
+	`, d.Project, d.Project, deliveryLogSize)
+	return err
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// hookServer records the requests it gets, answering each with the next of
+// its statuses, and then with 204.
+type hookServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	statuses []int
+	got      []hookRequest
+}
+
+type hookRequest struct {
+	header http.Header
+	body   []byte
+}
+
+func newHookServer(t *testing.T, statuses ...int) *hookServer {
+	s := &hookServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.got = append(s.got, hookRequest{header: r.Header.Clone(), body: body})
+		status := http.StatusNoContent
+		if len(s.statuses) > 0 {
+			status, s.statuses = s.statuses[0], s.statuses[1:]
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *hookServer) requests() []hookRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]hookRequest(nil), s.got...)
+}
+
+// deliverNow sends the deliveries that are due and waits for them. The
+// test servers listen on loopback, so local addresses are allowed.
+func deliverNow(t *testing.T, db *sql.DB) {
+	t.Helper()
+	deliverWith(t, newWebhookClient(true), db)
+}
+
+func deliverWith(t *testing.T, client *http.Client, db *sql.DB) {
+	t.Helper()
+	var dbMu sync.Mutex
+	s := newWebhookSender(client, db, &dbMu)
+	if _, err := s.deliverDue(); err != nil {
+		t.Fatal(err)
+	}
+	s.wait()
+}
+
+func edit(t *testing.T, db *sql.DB, fn func(j *journal) error) {
+	t.Helper()
+	if err := withJournal(db, "test", fn); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSignPayload(t *testing.T) {
+	tests := []struct {
+		secret, payload, want string
+	}{
+		// RFC 4231, test case 2.
+		{"Jefe", "what do ya want for nothing?", "5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843"},
+		{"", "", "b613679a0814d9ec772f95d778c35fc5ff1697c493715653c6c712144292c5ad"},
+	}
+	for _, tt := range tests {
+		if got := signPayload(tt.secret, []byte(tt.payload)); got != tt.want {
+			t.Errorf("signPayload(%q, %q) = %v, want %v", tt.secret, tt.payload, got, tt.want)
+		}
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	srv := newHookServer(t)
+	db := newTestDB(t)
+	pID := newTestProject(t, db)
+	h, err := createWebhook(db, pID, srv.URL, []eventKind{pairAdded})
+	if err != nil {
+		t.Fatal(err)
+	}
+	edit(t, db, func(j *journal) error {
+		if _, err := insertPair(j, pID, "a", "b"); err != nil {
+			return err
+		}
+		return changeBubbleState(j, pID, "a", started)
+	})
+	deliverNow(t, db)
+
+	got := srv.requests()
+	if len(got) != 1 {
+		t.Fatalf("got %v requests, want only the pair added", len(got))
+	}
+	req := got[0]
+	if sig, want := req.header.Get("X-Bubbles-Signature"), "sha256="+signPayload(h.Secret, req.body); sig != want {
+		t.Errorf("signature = %v, want %v", sig, want)
+	}
+	if kind := req.header.Get("X-Bubbles-Event"); kind != string(pairAdded) {
+		t.Errorf("event = %q, want %q", kind, pairAdded)
+	}
+	var payload struct {
+		Kind        eventKind `json:"kind"`
+		Left        string    `json:"left"`
+		Right       string    `json:"right"`
+		ProjectName string    `json:"project_name"`
+	}
+	if err := json.Unmarshal(req.body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Kind != pairAdded || payload.Left != "a" || payload.Right != "b" || payload.ProjectName != t.Name() {
+		t.Errorf("payload = %s", req.body)
+	}
+	deliveries, err := listDeliveries(db, pID, deliveriesShown)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || deliveries[0].State() != "delivered" || strconv.FormatInt(deliveries[0].ID, 10) != req.header.Get("X-Bubbles-Delivery") {
+		t.Errorf("deliveries = %+v", deliveries)
+	}
+}
+
+func TestWebhookRetry(t *testing.T) {
+	srv := newHookServer(t, http.StatusInternalServerError)
+	db := newTestDB(t)
+	pID := newTestProject(t, db)
+	if _, err := createWebhook(db, pID, srv.URL, []eventKind{pairAdded}); err != nil {
+		t.Fatal(err)
+	}
+	edit(t, db, func(j *journal) error {
+		_, err := insertPair(j, pID, "a", "b")
+		return err
+	})
+
+	start := time.Now()
+	deliverNow(t, db)
+	deliveries, err := listDeliveries(db, pID, deliveriesShown)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := deliveries[0]
+	if d.State() != "retrying" || d.Attempts != 1 || d.Status != http.StatusInternalServerError {
+		t.Fatalf("after a 500, delivery = %+v", d)
+	}
+	if wait := d.NextAttempt.Sub(start); wait < webhookBackoff || wait > webhookBackoff+time.Minute {
+		t.Errorf("next attempt in %v, want %v", wait, webhookBackoff)
+	}
+
+	// Nothing is sent before the next attempt is due.
+	deliverNow(t, db)
+	if n := len(srv.requests()); n != 1 {
+		t.Fatalf("sent %v requests before the retry was due", n)
+	}
+	if _, err := db.Exec("update deliveries set next_attempt = ?", time.Now().UTC().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	deliverNow(t, db)
+	got := srv.requests()
+	if len(got) != 2 || string(got[0].body) != string(got[1].body) {
+		t.Fatalf("got %v requests, want the same delivery twice", len(got))
+	}
+	deliveries, err = listDeliveries(db, pID, deliveriesShown)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := deliveries[0]; d.State() != "delivered" || d.Attempts != 2 || d.Status != http.StatusNoContent {
+		t.Errorf("after the retry, delivery = %+v", d)
+	}
+}
+
+func TestWebhookLocalTarget(t *testing.T) {
+	srv := newHookServer(t)
+	db := newTestDB(t)
+	pID := newTestProject(t, db)
+	if _, err := createWebhook(db, pID, srv.URL, []eventKind{pairAdded}); err != nil {
+		t.Fatal(err)
+	}
+	edit(t, db, func(j *journal) error {
+		_, err := insertPair(j, pID, "a", "b")
+		return err
+	})
+	deliverWith(t, newWebhookClient(false), db)
+	if n := len(srv.requests()); n != 0 {
+		t.Fatalf("sent %v requests to %v", n, srv.URL)
+	}
+	deliveries, err := listDeliveries(db, pID, deliveriesShown)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := deliveries[0]; d.State() != "retrying" || !strings.Contains(d.Error, errWebhookTarget.Error()) {
+		t.Errorf("delivery = %+v, want it refused", d)
+	}
+}
+
+func TestRefuseLocalAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		refused bool
+	}{
+		{"127.0.0.1:80", true},
+		{"[::1]:443", true},
+		{"10.1.2.3:80", true},
+		{"172.16.0.1:80", true},
+		{"192.168.1.1:80", true},
+		{"169.254.169.254:80", true},
+		{"[fe80::1]:80", true},
+		{"[fd00::1]:80", true},
+		{"[::ffff:127.0.0.1]:80", true},
+		{"0.0.0.0:80", true},
+		{"93.184.216.34:443", false},
+		{"[2606:2800:220:1::]:443", false},
+	}
+	for _, tt := range tests {
+		err := refuseLocalAddress("tcp", tt.address, nil)
+		if refused := errors.Is(err, errWebhookTarget); refused != tt.refused {
+			t.Errorf("refuseLocalAddress(%v) = %v, want refused %v", tt.address, err, tt.refused)
+		}
+	}
+}
+
+func TestFinishAttemptBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		wait     time.Duration
+	}{
+		{0, webhookBackoff},
+		{1, 2 * webhookBackoff},
+		{2, 4 * webhookBackoff},
+		{webhookAttempts - 2, webhookBackoff << (webhookAttempts - 2)},
+		{webhookAttempts - 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.attempts), func(t *testing.T) {
+			db := newTestDB(t)
+			now := time.Now().UTC()
+			result, err := db.Exec("insert into deliveries (webhook, project, kind, attempts, next_attempt) values (1, 1, ?, ?, ?)", pairAdded, tt.attempts, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			id, err := result.LastInsertId()
+			if err != nil {
+				t.Fatal(err)
+			}
+			d := delivery{ID: id, Project: 1, Attempts: tt.attempts}
+			if err := finishAttempt(db, d, http.StatusBadGateway, io.ErrUnexpectedEOF); err != nil {
+				t.Fatal(err)
+			}
+			var next sql.NullTime
+			if err := db.QueryRow("select next_attempt from deliveries where id = ?", id).Scan(&next); err != nil {
+				t.Fatal(err)
+			}
+			if tt.wait == 0 {
+				if next.Valid {
+					t.Errorf("retrying at %v after %v attempts, want to give up", next.Time, tt.attempts+1)
+				}
+				return
+			}
+			if wait := next.Time.Sub(now); !next.Valid || wait < tt.wait || wait > tt.wait+time.Minute {
+				t.Errorf("next attempt in %v, want %v", wait, tt.wait)
+			}
+		})
+	}
+}
+
+func TestDeliveryLogTrim(t *testing.T) {
+	db := newTestDB(t)
+	now := time.Now().UTC()
+	for i := 0; i < deliveryLogSize+10; i++ {
+		if _, err := db.Exec("insert into deliveries (webhook, project, kind, attempts, delivered) values (1, 1, ?, 1, ?)", pairAdded, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A pending delivery of the project, one of another project and the
+	// delivery being finished.
+	for _, pID := range []int64{1, 2, 1} {
+		if _, err := db.Exec("insert into deliveries (webhook, project, kind, next_attempt) values (1, ?, ?, ?)", pID, pairAdded, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var last int64
+	if err := db.QueryRow("select max(id) from deliveries").Scan(&last); err != nil {
+		t.Fatal(err)
+	}
+	if err := finishAttempt(db, delivery{ID: last, Project: 1}, http.StatusOK, nil); err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[int64]int)
+	rows, err := db.Query("select project, count(*) from deliveries group by project")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var pID int64
+		var n int
+		if err := rows.Scan(&pID, &n); err != nil {
+			t.Fatal(err)
+		}
+		counts[pID] = n
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if counts[1] != deliveryLogSize || counts[2] != 1 {
+		t.Errorf("kept %v deliveries, want %v of project 1 and 1 of project 2", counts, deliveryLogSize)
+	}
+	var pending, newest int
+	if err := db.QueryRow("select count(*) from deliveries where project = 1 and next_attempt is not null").Scan(&pending); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow("select count(*) from deliveries where id = ?", last).Scan(&newest); err != nil {
+		t.Fatal(err)
+	}
+	if pending != 1 || newest != 1 {
+		t.Errorf("the pending delivery (%v) or the finished one (%v) was trimmed", pending, newest)
+	}
+}
+
+func TestBubbleReady(t *testing.T) {
+	srv := newHookServer(t)
+	db := newTestDB(t)
+	pID := newTestProject(t, db,
+		dep{Left: "a", Right: "b"}, dep{Left: "a", Right: "c"},
+		dep{Left: "b", Right: "d"}, dep{Left: "c", Right: "d"},
+	)
+	if _, err := createWebhook(db, pID, srv.URL, []eventKind{bubbleReady}); err != nil {
+		t.Fatal(err)
+	}
+	steps := []struct {
+		name string
+		edit func(j *journal) error
+		want []string
+	}{
+		{"start a", func(j *journal) error { return changeBubbleState(j, pID, "a", started) }, nil},
+		{"finish a", func(j *journal) error { return changeBubbleState(j, pID, "a", done) }, []string{"b", "c"}},
+		{"finish b", func(j *journal) error { return changeBubbleState(j, pID, "b", done) }, nil},
+		{"finish c", func(j *journal) error { return changeBubbleState(j, pID, "c", done) }, []string{"d"}},
+		{"new bubble", func(j *journal) error {
+			_, err := insertPair(j, pID, "d", "e")
+			return err
+		}, nil},
+		{"reopen a", func(j *journal) error { return changeBubbleState(j, pID, "a", initial) }, []string{"a"}},
+	}
+	var seen int
+	for _, step := range steps {
+		edit(t, db, step.edit)
+		deliverNow(t, db)
+		var got []string
+		for _, req := range srv.requests()[seen:] {
+			var payload struct {
+				Bubble string `json:"bubble"`
+			}
+			if err := json.Unmarshal(req.body, &payload); err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, payload.Bubble)
+		}
+		seen += len(got)
+		if !slices.Equal(got, step.want) {
+			t.Errorf("%v: ready %q, want %q", step.name, got, step.want)
+		}
+	}
+}